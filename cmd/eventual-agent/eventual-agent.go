@@ -4,6 +4,10 @@ import (
 	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
 	"github.com/josh-tracey/eventual-agent/internal/adapters/framework/left/grpc"
 	"github.com/josh-tracey/eventual-agent/internal/adapters/framework/left/websocket"
+	"github.com/josh-tracey/eventual-agent/internal/adapters/framework/right/filestore"
+	"github.com/josh-tracey/eventual-agent/internal/adapters/framework/right/memqueue"
+	"github.com/josh-tracey/eventual-agent/internal/adapters/framework/right/sink"
+	"github.com/josh-tracey/eventual-agent/internal/adapters/framework/right/token"
 	"github.com/josh-tracey/eventual-agent/internal/adapters/services"
 	"github.com/josh-tracey/eventual-agent/internal/ports"
 	"github.com/josh-tracey/scribe"
@@ -14,13 +18,37 @@ func main() {
 	var logger *scribe.Logger = scribe.NewLogger()
 	var publishChannel chan *core.PeerEvent = make(chan *core.PeerEvent, 32)
 	var subsChannel chan *core.PeerRequest = make(chan *core.PeerRequest, 32)
+	var inboxChannel chan *core.PeerEvent = make(chan *core.PeerEvent, 32)
 	var eventQueueChan chan *core.CloudEvent = make(chan *core.CloudEvent, 32)
+	var sinkCopies chan *core.SinkCopy = make(chan *core.SinkCopy, 32)
 	var publisher ports.Publisher
 	var eventQueue ports.EventQueue
 
-	subs = core.NewAdapter(logger)
+	ratePolicy, err := core.LoadRatePolicy()
 
-	eventQueue, err := services.NewEventQueue(
+	if err != nil {
+		panic("Rate limit policy failed to load: " + err.Error())
+	}
+
+	var rules ports.RulesStorePort = filestore.NewRulesStore(filestore.RulesPath)
+	table, err := rules.Load()
+
+	if err != nil {
+		panic("Routing rules failed to load: " + err.Error())
+	}
+
+	router, err := core.NewRouter(table, sinkCopies)
+
+	if err != nil {
+		panic("Routing rules are invalid: " + err.Error())
+	}
+
+	sinks := services.NewSinks(logger, sinkCopies, sink.Open)
+	sinks.Configure(table.Sinks)
+
+	subs = core.NewAdapter(logger, core.NewLimits(ratePolicy), router)
+
+	eventQueue, err = services.NewEventQueue(
 		subs,
 		eventQueueChan,
 		subsChannel,
@@ -31,17 +59,70 @@ func main() {
 		panic("EventQueue to Websocket service failed to initialize")
 	}
 
-	publisher, err2 := services.NewPublisher(subs, logger, publishChannel)
+	verifier, err := token.NewVerifierFromEnv(logger)
+
+	if err != nil {
+		panic("Token verifier failed to initialize: " + err.Error())
+	}
+
+	signer, err := token.NewSignerFromEnv()
+
+	if err != nil {
+		panic("Token signer failed to initialize: " + err.Error())
+	}
+
+	publisher, err2 := services.NewPublisher(subs, logger, publishChannel, signer)
 
 	if err2 != nil {
 		panic("Publisher failed to initialize")
 	}
 
+	var history ports.MessageQueuePort
+	history, err = memqueue.Open(memqueue.DefaultPath, memqueue.DefaultSize)
+
+	if err != nil {
+		panic("History failed to load: " + err.Error())
+	}
+
+	var cursors ports.CursorStorePort = filestore.NewCursorStore(filestore.CursorsPath)
+	saved, err := cursors.Load()
+
+	if err != nil {
+		panic("Durable subscriptions failed to load: " + err.Error())
+	}
+
+	durables := core.NewDurableRegistry(saved, cursors.Save)
+
+	var schedule ports.ScheduleStorePort = filestore.NewScheduleStore(filestore.SchedulePath)
+	pending, err := schedule.Load()
+
+	if err != nil {
+		panic("Scheduled events failed to load: " + err.Error())
+	}
+
+	scheduler := core.NewScheduler(pending, schedule.Save)
+
+	var schemaStore ports.SchemaStorePort = filestore.NewSchemaStore(filestore.SchemaDir)
+	documents, err := schemaStore.Load()
+
+	if err != nil {
+		panic("Schemas failed to load: " + err.Error())
+	}
+
+	schemas, err := core.NewSchemaRegistry(documents)
+
+	if err != nil {
+		panic("Schemas failed to compile: " + err.Error())
+	}
+
 	var ws ports.PeerClient
-	ws = websocket.NewAdapter(subs, eventQueueChan)
-	grpcServer := grpc.New(subs, logger, publishChannel, subsChannel)
+	ws = websocket.NewAdapter(subs, eventQueueChan, publishChannel, inboxChannel, verifier, history, durables, scheduler, schemas)
+	grpcServer := grpc.New(subs, logger, publishChannel, subsChannel, inboxChannel, verifier, history, scheduler, schemas)
 
 	go logger.Start()
+	go durables.Run(logger)
+	go router.Watch(rules.Load, rules.Modified, sinks.Configure, logger)
+	go sinks.Run()
 	go grpcServer.Run()
 	go ws.ListenAndServe()
 	go publisher.Run()
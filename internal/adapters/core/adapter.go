@@ -1,33 +1,92 @@
 package core
 
 import (
+	"hash/fnv"
 	"sync"
 
 	"github.com/josh-tracey/scribe"
 )
 
+// ShardCount - Number of lock stripes channels are spread across
+const ShardCount = 64
+
+// shard - Subscriptions, routing index, retained events and presence for the
+// channels hashing to it
+type shard struct {
+	subs     map[string]*sub
+	index    *index
+	retained map[string]map[string]retainedEvent
+	presence map[string]map[string]Member
+	lock     sync.RWMutex
+}
+
+func newShard() *shard {
+	return &shard{
+		subs:     make(map[string]*sub),
+		index:    newIndex(),
+		retained: make(map[string]map[string]retainedEvent),
+		presence: make(map[string]map[string]Member),
+	}
+}
+
 type Adapter struct {
 	logger      *scribe.Logger
-	subs        map[string]*sub
+	shards      [ShardCount]*shard
 	peerClients map[string]*peer
 	peerServers map[string]*peer
-	lock        sync.RWMutex
+	limits      *Limits
+	replies     *replies
+	groups      *groups
+	dedup       *dedup
+	router      *Router
+	inboxPeers  *inboxPeers
+	peerLock    sync.RWMutex
 }
 
-func NewAdapter(logger *scribe.Logger) *Adapter {
-	return &Adapter{
+func NewAdapter(logger *scribe.Logger, limits *Limits, router *Router) *Adapter {
+	adapt := &Adapter{
 		logger:      logger,
-		subs:        map[string]*sub{"global": newSub("global")},
+		limits:      limits,
+		router:      router,
+		replies:     newReplies(),
+		groups:      newGroups(),
+		dedup:       newDedup(),
+		inboxPeers:  newInboxPeers(),
 		peerServers: make(map[string]*peer),
 		peerClients: make(map[string]*peer),
-		lock:        sync.RWMutex{},
+		peerLock:    sync.RWMutex{},
 	}
+	for i := range adapt.shards {
+		adapt.shards[i] = newShard()
+	}
+	adapt.GetSub("global")
+	return adapt
 }
 
 func (adapt *Adapter) GetLogger() *scribe.Logger {
 	return adapt.logger
 }
 
+func (adapt *Adapter) Limits() *Limits {
+	return adapt.limits
+}
+
+// Route - Events to deliver for a publish once the routing rules are
+// applied, sink copies being queued as a side effect
+func (adapt *Adapter) Route(event CloudEvent) []CloudEvent {
+	if adapt.router == nil {
+		return []CloudEvent{event}
+	}
+	return adapt.router.Route(event)
+}
+
+// shardFor - Shard owning a channel
+func (adapt *Adapter) shardFor(channel string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(channel))
+	return adapt.shards[h.Sum32()%ShardCount]
+}
+
 // GetSub - Thread Safe method of getting a Sub
 func (adapt *Adapter) GetSub(channel string) *sub {
 	defer func() {
@@ -36,13 +95,22 @@ func (adapt *Adapter) GetSub(channel string) *sub {
 		}
 	}()
 
-	adapt.lock.Lock()
-	defer adapt.lock.Unlock()
-	if _, ok := adapt.subs[channel]; !ok {
-		adapt.subs[channel] = newSub(channel)
+	s := adapt.shardFor(channel)
+
+	s.lock.RLock()
+	existing, ok := s.subs[channel]
+	s.lock.RUnlock()
+	if ok {
+		return existing
 	}
 
-	return adapt.subs[channel]
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.subs[channel]; !ok {
+		s.subs[channel] = newSub(channel)
+	}
+
+	return s.subs[channel]
 }
 
 func (adapt *Adapter) GetPeer(addr string, ephemeral bool) *peer {
@@ -52,25 +120,26 @@ func (adapt *Adapter) GetPeer(addr string, ephemeral bool) *peer {
 		}
 	}()
 
-	adapt.lock.Lock()
-	defer adapt.lock.Unlock()
-
-	if !ephemeral {
+	adapt.peerLock.Lock()
+	defer adapt.peerLock.Unlock()
 
-		if _, ok := adapt.peerServers[addr]; !ok {
-			adapt.peerServers[addr] = newPeer(addr)
-		}
+	peers := adapt.peers(ephemeral)
+	if _, ok := peers[addr]; !ok {
+		peers[addr] = newPeer(addr)
+	}
 
-		return adapt.peerServers[addr]
-	} else {
-		if _, ok := adapt.peerClients[addr]; !ok {
-			adapt.peerClients[addr] = newPeer(addr)
-		}
+	return peers[addr]
+}
 
-		return adapt.peerClients[addr]
+// peers - Peer map for the kind of peer, callers must hold peerLock
+func (adapt *Adapter) peers(ephemeral bool) map[string]*peer {
+	if ephemeral {
+		return adapt.peerClients
 	}
+	return adapt.peerServers
 }
 
+// RemovePeer - Forgets a peer, taking a peer server out of its consumer groups
 func (adapt *Adapter) RemovePeer(addr string, ephemeral bool) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -78,14 +147,14 @@ func (adapt *Adapter) RemovePeer(addr string, ephemeral bool) {
 		}
 	}()
 
-	adapt.lock.Lock()
-	defer adapt.lock.Unlock()
-
 	if !ephemeral {
-		delete(adapt.peerServers, addr)
-	} else {
-		delete(adapt.peerClients, addr)
+		adapt.leavePeerGroups(addr)
 	}
+
+	adapt.peerLock.Lock()
+	defer adapt.peerLock.Unlock()
+
+	delete(adapt.peers(ephemeral), addr)
 }
 
 func (adapt *Adapter) RemovePeerFromChannel(addr string, channel string, ephemeral bool) {
@@ -95,14 +164,10 @@ func (adapt *Adapter) RemovePeerFromChannel(addr string, channel string, ephemer
 		}
 	}()
 
-	adapt.lock.Lock()
-	defer adapt.lock.Unlock()
+	adapt.peerLock.RLock()
+	defer adapt.peerLock.RUnlock()
 
-	if !ephemeral {
-		adapt.peerServers[addr].RemoveChannel(channel)
-	} else {
-		adapt.peerClients[addr].RemoveChannel(channel)
-	}
+	adapt.peers(ephemeral)[addr].RemoveChannel(channel)
 }
 
 func (adapt *Adapter) RemoveClientFromChannel(client string, channel string) {
@@ -112,10 +177,7 @@ func (adapt *Adapter) RemoveClientFromChannel(client string, channel string) {
 		}
 	}()
 
-	adapt.lock.Lock()
-	defer adapt.lock.Unlock()
-
-	adapt.subs[channel].RemoveClientId(client)
+	adapt.RemoveClient(client, []string{channel})
 }
 
 func (adapt *Adapter) HasPeerId(addr string, ephemeral bool) bool {
@@ -125,38 +187,53 @@ func (adapt *Adapter) HasPeerId(addr string, ephemeral bool) bool {
 		}
 	}()
 
-	adapt.lock.RLock()
-	defer adapt.lock.RUnlock()
+	adapt.peerLock.RLock()
+	defer adapt.peerLock.RUnlock()
 
-	if !ephemeral {
-		if _, ok := adapt.peerServers[addr]; ok {
-			return true
-		}
-	} else {
-		if _, ok := adapt.peerClients[addr]; ok {
-			return true
-		}
-	}
+	_, ok := adapt.peers(ephemeral)[addr]
+	return ok
+}
 
-	return false
+// GetPeerServers - Snapshot of subscribed peer server addresses
+func (adapt *Adapter) GetPeerServers() []string {
+	return adapt.peerSnapshot(false)
 }
 
-func (adapt *Adapter) GetPeerServers() map[string]*peer {
-	adapt.lock.RLock()
-	defer adapt.lock.RUnlock()
-	return adapt.peerServers
+// GetPeerClients - Snapshot of connected client peer addresses
+func (adapt *Adapter) GetPeerClients() []string {
+	return adapt.peerSnapshot(true)
 }
 
-func (adapt *Adapter) GetPeerClients() map[string]*peer {
-	adapt.lock.RLock()
-	defer adapt.lock.RUnlock()
-	return adapt.peerClients
+func (adapt *Adapter) peerSnapshot(ephemeral bool) []string {
+	adapt.peerLock.RLock()
+	defer adapt.peerLock.RUnlock()
+
+	peers := adapt.peers(ephemeral)
+	addrs := make([]string, 0, len(peers))
+	for addr := range peers {
+		addrs = append(addrs, addr)
+	}
+	return addrs
 }
 
-func (adapt *Adapter) GetSubs() map[string]*sub {
-	adapt.lock.RLock()
-	defer adapt.lock.RUnlock()
-	return adapt.subs
+// GetSubs - Snapshot of the channels with subscriptions
+func (adapt *Adapter) GetSubs() []string {
+	var channels []string
+	adapt.eachShard(func(s *shard) {
+		for channel := range s.subs {
+			channels = append(channels, channel)
+		}
+	})
+	return channels
+}
+
+// eachShard - Calls fn with every shard read locked in turn
+func (adapt *Adapter) eachShard(fn func(s *shard)) {
+	for _, s := range adapt.shards {
+		s.lock.RLock()
+		fn(s)
+		s.lock.RUnlock()
+	}
 }
 
 // Remove - Thread Safe method of removing a channel from Sub
@@ -167,9 +244,11 @@ func (adapt *Adapter) Remove(channel string) {
 		}
 	}()
 
-	adapt.lock.Lock()
-	defer adapt.lock.Unlock()
-	delete(adapt.subs, channel)
+	s := adapt.shardFor(channel)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.subs, channel)
+	s.index.remove(channel, func(Subscriber) bool { return true })
 }
 
 func (adapt *Adapter) RemoveClientId(client string) {
@@ -179,21 +258,12 @@ func (adapt *Adapter) RemoveClientId(client string) {
 		}
 	}()
 
-	var done chan bool = make(chan bool)
-	go func() {
-		adapt.lock.Lock()
-		defer adapt.lock.Unlock()
-		for _, v := range adapt.subs {
-			for i, clientId := range v.clients {
-				if *clientId[1] == client {
-					v.clients = removeArr(v.clients, i)
-				}
-			}
-		}
-		done <- true
-		close(done)
-	}()
-	<-done
+	adapt.eachShard(func(s *shard) {
+		for _, v := range s.subs {
+			v.RemoveClientId(client)
+		}
+		s.index.removeClient(client)
+	})
 }
 
 func (adapt *Adapter) GetClients() []string {
@@ -204,30 +274,76 @@ func (adapt *Adapter) GetClients() []string {
 	}()
 
 	var clients []string
-	for _, sub := range adapt.subs {
-		clients = append(clients, sub.GetClients()...)
-	}
+	adapt.eachShard(func(s *shard) {
+		for _, sub := range s.subs {
+			clients = append(clients, sub.GetClients()...)
+		}
+	})
 	return clients
 }
 
 func (adapt *Adapter) AddPeer(addr string, channel string, ephemeral bool) string {
+	return adapt.AddGroupPeer(addr, channel, "", ephemeral)
+}
+
+// AddGroupPeer - Subscribes a peer to channel as a member of a consumer group,
+// or to every event when group is empty
+func (adapt *Adapter) AddGroupPeer(addr string, channel string, group string, ephemeral bool) string {
 	defer func() {
 		if r := recover(); r != nil {
-			adapt.logger.Error("core::Adapter.AddPeer => %s", r)
+			adapt.logger.Error("core::Adapter.AddGroupPeer => %s", r)
 		}
 	}()
 
-	peer := adapt.GetPeer(addr, ephemeral)
-	peer.AddChannel(channel)
 	if ephemeral {
-		if adapt.subs[channel] == nil {
-			adapt.subs[channel] = newSub(channel)
-		}
-		return adapt.subs[channel].AddClient(&addr)
+		return adapt.addSubscriber(channel, Subscriber{Client: addr, Group: group})
+	}
+	adapt.GetPeer(addr, false).AddChannel(channel)
+	if group != "" {
+		adapt.JoinPeerGroup(addr, channel, group)
 	}
 	return ""
 }
 
+// AddDurablePeer - Subscribes a client to channel on behalf of a durable
+// subscription
+func (adapt *Adapter) AddDurablePeer(addr string, channel string, durable string) string {
+	defer func() {
+		if r := recover(); r != nil {
+			adapt.logger.Error("core::Adapter.AddDurablePeer => %s", r)
+		}
+	}()
+
+	return adapt.addSubscriber(channel, Subscriber{Client: addr, Durable: durable})
+}
+
+func (adapt *Adapter) addSubscriber(channel string, s Subscriber) string {
+	adapt.GetPeer(s.Client, true).AddChannel(channel)
+	s.RefID = adapt.GetSub(channel).AddClient(&s.Client)
+	adapt.shardFor(channel).index.add(channel, s)
+	return s.RefID
+}
+
+// Subscribers - Lock free lookup of the client subscriptions to a channel
+func (adapt *Adapter) Subscribers(channel string) []Subscriber {
+	return adapt.shardFor(channel).index.subscribers(channel)
+}
+
+// Unsubscribe - Removes a single client subscription created by AddPeer
+func (adapt *Adapter) Unsubscribe(addr string, channel string, refID string) {
+	defer func() {
+		if r := recover(); r != nil {
+			adapt.logger.Error("core::Adapter.Unsubscribe => %s", r)
+		}
+	}()
+
+	adapt.shardFor(channel).index.remove(channel, func(s Subscriber) bool { return s.RefID == refID })
+	adapt.GetSub(channel).RemoveRef(refID)
+	if adapt.HasPeerId(addr, true) {
+		adapt.RemovePeerFromChannel(addr, channel, true)
+	}
+}
+
 func (adapt *Adapter) RemoveClient(ID string, channels []string) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -237,5 +353,6 @@ func (adapt *Adapter) RemoveClient(ID string, channels []string) {
 
 	for _, channel := range channels {
 		adapt.GetSub(channel).RemoveClientId(ID)
+		adapt.shardFor(channel).index.remove(channel, func(s Subscriber) bool { return s.Client == ID })
 	}
 }
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+
+	"github.com/josh-tracey/scribe"
+)
+
+// BenchmarkAdapterSubscriptions - Mixes subscribes, unsubscribes and
+// subscriber lookups from parallel goroutines across many channels, the
+// load pool workers put on the registry. Three in four operations are
+// lookups, as publishes outnumber subscription changes.
+func BenchmarkAdapterSubscriptions(b *testing.B) {
+	const channelCount = 1024
+
+	adapt := NewAdapter(scribe.NewLogger(), NewLimits(RatePolicy{}), nil)
+	channels := make([]string, channelCount)
+	for i := range channels {
+		channels[i] = fmt.Sprintf("orders.region%d.created", i)
+		for j := 0; j < 4; j++ {
+			adapt.AddPeer(fmt.Sprintf("seed-%d", j), channels[i], true)
+		}
+	}
+
+	var clients int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		id := atomic.AddInt64(&clients, 1)
+		client := fmt.Sprintf("client-%d", id)
+		random := rand.New(rand.NewSource(id))
+
+		type subscription struct{ channel, refID string }
+		var subscribed []subscription
+		for i := 0; pb.Next(); i++ {
+			channel := channels[random.Intn(channelCount)]
+			switch {
+			case i%8 == 0:
+				subscribed = append(subscribed, subscription{channel, adapt.AddPeer(client, channel, true)})
+			case i%8 == 4 && len(subscribed) > 0:
+				s := subscribed[0]
+				subscribed = subscribed[1:]
+				adapt.Unsubscribe(client, s.channel, s.refID)
+			default:
+				_ = adapt.Subscribers(channel)
+			}
+		}
+	})
+}
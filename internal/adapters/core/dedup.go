@@ -0,0 +1,145 @@
+package core
+
+import (
+	"expvar"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// How long a published event's ID is remembered, DEDUP_WINDOW; zero
+	// disables deduplication
+	DedupWindow = 5 * time.Minute
+	// Per source overrides of DedupWindow, DEDUP_SOURCE_WINDOWS
+	DedupSourceWindows = map[string]time.Duration{}
+)
+
+func init() {
+	if window, err := time.ParseDuration(os.Getenv("DEDUP_WINDOW")); err == nil && window >= 0 {
+		DedupWindow = window
+	}
+	if value, ok := os.LookupEnv("DEDUP_SOURCE_WINDOWS"); ok {
+		windows, err := ParseDedupWindows(value)
+		if err != nil {
+			panic("Invalid DEDUP_SOURCE_WINDOWS: " + err.Error())
+		}
+		DedupSourceWindows = windows
+	}
+}
+
+// Duplicate publishes dropped, keyed by transport, published on /debug/vars
+var duplicateEvents = expvar.NewMap("duplicate_events")
+
+// ParseDedupWindows - Parses comma separated source=duration pairs. Sources
+// are split on their last '=' so URIs with query strings survive.
+func ParseDedupWindows(value string) (map[string]time.Duration, error) {
+	windows := make(map[string]time.Duration)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("expected source=duration, got %q", pair)
+		}
+		window, err := time.ParseDuration(pair[i+1:])
+		if err != nil || window < 0 {
+			return nil, fmt.Errorf("invalid window for %s: %q", pair[:i], pair[i+1:])
+		}
+		windows[pair[:i]] = window
+	}
+	return windows, nil
+}
+
+// DedupWindowFor - Window event IDs from source are remembered for
+func DedupWindowFor(source string) time.Duration {
+	if window, ok := DedupSourceWindows[source]; ok {
+		return window
+	}
+	return DedupWindow
+}
+
+type seenID struct {
+	id      string
+	expires time.Time
+}
+
+// seenIDs - Event IDs one source published within its window, in the order
+// they expire
+type seenIDs struct {
+	expires map[string]time.Time
+	order   []seenID
+}
+
+func (s *seenIDs) prune(now time.Time) {
+	for len(s.order) > 0 && !s.order[0].expires.After(now) {
+		if s.expires[s.order[0].id] == s.order[0].expires {
+			delete(s.expires, s.order[0].id)
+		}
+		s.order = s.order[1:]
+	}
+}
+
+// dedup - Recently published (source, id) pairs, the CloudEvents uniqueness
+// key
+type dedup struct {
+	sources map[string]*seenIDs
+	swept   time.Time
+	lock    sync.Mutex
+}
+
+func newDedup() *dedup {
+	return &dedup{sources: make(map[string]*seenIDs), swept: time.Now()}
+}
+
+// sweep - Drops expired IDs of every source so quiet sources do not hold on
+// to memory. Caller holds the lock.
+func (d *dedup) sweep(now time.Time) {
+	if now.Sub(d.swept) < time.Minute {
+		return
+	}
+	d.swept = now
+	for source, seen := range d.sources {
+		seen.prune(now)
+		if len(seen.expires) == 0 {
+			delete(d.sources, source)
+		}
+	}
+}
+
+// Duplicate - Records a published event's (source, id) and reports whether
+// it was already published within the source's window, counting duplicates
+// by the transport they arrived on. Events without an ID are never
+// duplicates.
+func (adapt *Adapter) Duplicate(event CloudEvent, transport string) bool {
+	window := DedupWindowFor(event.Source)
+	if window <= 0 || event.ID == "" {
+		return false
+	}
+	now := time.Now()
+
+	d := adapt.dedup
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.sweep(now)
+
+	seen, ok := d.sources[event.Source]
+	if !ok {
+		seen = &seenIDs{expires: make(map[string]time.Time)}
+		d.sources[event.Source] = seen
+	}
+	seen.prune(now)
+
+	if _, ok := seen.expires[event.ID]; ok {
+		duplicateEvents.Add(transport, 1)
+		return true
+	}
+	expires := now.Add(window)
+	seen.expires[event.ID] = expires
+	seen.order = append(seen.order, seenID{id: event.ID, expires: expires})
+	return false
+}
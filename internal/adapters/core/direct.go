@@ -0,0 +1,101 @@
+package core
+
+import (
+	"strings"
+	"sync"
+)
+
+// InboxPrefix - Direct messages to a user are authorized as publishes to
+// InboxPrefix + subject, so tokens grant them with ordinary channel patterns
+const InboxPrefix = "inbox."
+
+// DirectEvent - Incoming request to deliver an event to every connection and
+// gRPC peer server of a user, or to a single connection
+type DirectEvent struct {
+	Type       string     `json:"type"`
+	ID         string     `json:"id,omitempty"`
+	User       string     `json:"user,omitempty"`
+	Connection string     `json:"connection,omitempty"`
+	Event      CloudEvent `json:"event"`
+}
+
+// DirectMessage - Outgoing event addressed to the receiving user or
+// connection, naming the sender and its opaque connection ID so it can reply
+type DirectMessage struct {
+	Type       string     `json:"type"`
+	From       string     `json:"from"`
+	Connection string     `json:"connection"`
+	Event      CloudEvent `json:"event"`
+}
+
+func (d DirectEvent) isMessage() {}
+
+func (d DirectMessage) isMessage() {}
+
+// InboxChannel - Channel a direct message to subject is authorized against
+func InboxChannel(subject string) string {
+	return InboxPrefix + subject
+}
+
+// InboxUser - User whose direct messages channel carries, false for other
+// channels and for patterns matching more than one inbox
+func InboxUser(channel string) (string, bool) {
+	if !strings.HasPrefix(channel, InboxPrefix) {
+		return "", false
+	}
+	user := strings.TrimPrefix(channel, InboxPrefix)
+	if user == "" || user == "*" || user == ">" {
+		return "", false
+	}
+	return user, true
+}
+
+// inboxPeers - Peer servers subscribed over gRPC to a user's inbox channel,
+// by user, which receive that user's direct messages
+type inboxPeers struct {
+	subjects map[string]map[string]struct{}
+	lock     sync.RWMutex
+}
+
+func newInboxPeers() *inboxPeers {
+	return &inboxPeers{subjects: make(map[string]map[string]struct{})}
+}
+
+// AddInboxPeer - Registers a peer server as a recipient of user's direct
+// messages
+func (adapt *Adapter) AddInboxPeer(user string, peerServer string) {
+	i := adapt.inboxPeers
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.subjects[user] == nil {
+		i.subjects[user] = make(map[string]struct{})
+	}
+	i.subjects[user][peerServer] = struct{}{}
+}
+
+// DirectPeerEvents - Deliveries of a direct message to subject's peer
+// servers, forgetting those no longer subscribed
+func (adapt *Adapter) DirectPeerEvents(subject string, msg DirectMessage) []*PeerEvent {
+	i := adapt.inboxPeers
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	var events []*PeerEvent
+	for peerServer := range i.subjects[subject] {
+		if !adapt.HasPeerId(peerServer, false) {
+			delete(i.subjects[subject], peerServer)
+			continue
+		}
+		events = append(events, &PeerEvent{
+			PeerServer: peerServer,
+			Event:      msg.Event,
+			Channel:    InboxChannel(subject),
+			From:       msg.From,
+			Connection: msg.Connection,
+		})
+	}
+	if len(i.subjects[subject]) == 0 {
+		delete(i.subjects, subject)
+	}
+	return events
+}
@@ -0,0 +1,234 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/josh-tracey/scribe"
+)
+
+var (
+	ErrDurableNotFound = errors.New("durable subscription not found")
+
+	// How often changed cursors are saved, DURABLE_FLUSH_INTERVAL
+	DurableFlushInterval = time.Second
+)
+
+func init() {
+	if interval, err := time.ParseDuration(os.Getenv("DURABLE_FLUSH_INTERVAL")); err == nil && interval > 0 {
+		DurableFlushInterval = interval
+	}
+}
+
+const (
+	AckRewound = "rewound"
+	AckDeleted = "deleted"
+)
+
+// Cursor - Position of a durable subscription in a channel's event log, the
+// EventCursor of the last delivered event or the time to resume from
+type Cursor struct {
+	After string    `json:"after,omitempty"`
+	Since time.Time `json:"since,omitempty"`
+}
+
+// Query - History query returning the events after the cursor
+func (c Cursor) Query() HistoryQuery {
+	return HistoryQuery{After: c.After, Since: c.Since, Limit: MaxHistoryLimit}
+}
+
+// Durable - Named subscription of a token subject whose cursors outlive the
+// connection and the process
+type Durable struct {
+	Name    string            `json:"name"`
+	Subject string            `json:"subject"`
+	Cursors map[string]Cursor `json:"cursors"`
+	Updated time.Time         `json:"updated"`
+}
+
+// DurablesMessage - Outgoing reply listing a subject's durable subscriptions
+type DurablesMessage struct {
+	Type     string    `json:"type"`
+	ID       string    `json:"id,omitempty"`
+	Durables []Durable `json:"durables"`
+}
+
+func (d DurablesMessage) isMessage() {}
+
+func (d *Durable) copy() Durable {
+	cursors := make(map[string]Cursor, len(d.Cursors))
+	for channel, cursor := range d.Cursors {
+		cursors[channel] = cursor
+	}
+	return Durable{Name: d.Name, Subject: d.Subject, Cursors: cursors, Updated: d.Updated}
+}
+
+// DurableRegistry - Durable subscriptions keyed by subject and name. Changes
+// are written through save by Run so cursor updates never wait on storage.
+type DurableRegistry struct {
+	durables map[string]*Durable
+	dirty    bool
+	save     func([]Durable) error
+	lock     sync.Mutex
+}
+
+func NewDurableRegistry(durables []Durable, save func([]Durable) error) *DurableRegistry {
+	r := &DurableRegistry{
+		durables: make(map[string]*Durable, len(durables)),
+		save:     save,
+	}
+	for i := range durables {
+		d := durables[i]
+		if d.Cursors == nil {
+			d.Cursors = make(map[string]Cursor)
+		}
+		r.durables[durableKey(d.Subject, d.Name)] = &d
+	}
+	return r
+}
+
+func durableKey(subject string, name string) string {
+	return subject + "\x00" + name
+}
+
+// Open - Creates or resumes a durable subscription to channels. Channels new
+// to the subscription start at the current time.
+func (r *DurableRegistry) Open(subject string, name string, channels []string) Durable {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := durableKey(subject, name)
+	d, ok := r.durables[key]
+	if !ok {
+		d = &Durable{Name: name, Subject: subject, Cursors: make(map[string]Cursor)}
+		r.durables[key] = d
+	}
+	now := time.Now()
+	for _, channel := range channels {
+		if _, ok := d.Cursors[channel]; !ok {
+			d.Cursors[channel] = Cursor{Since: now}
+		}
+	}
+	d.Updated = now
+	r.dirty = true
+	return d.copy()
+}
+
+// Get - A subject's durable subscription
+func (r *DurableRegistry) Get(subject string, name string) (Durable, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	d, ok := r.durables[durableKey(subject, name)]
+	if !ok {
+		return Durable{}, false
+	}
+	return d.copy(), true
+}
+
+// Advance - Moves a cursor past an event once it has been delivered
+func (r *DurableRegistry) Advance(subject string, name string, channel string, event CloudEvent) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	d, ok := r.durables[durableKey(subject, name)]
+	if !ok {
+		return
+	}
+	if _, ok := d.Cursors[channel]; !ok {
+		return
+	}
+	d.Cursors[channel] = Cursor{After: EventCursor(event)}
+	d.Updated = time.Now()
+	r.dirty = true
+}
+
+// Rewind - Moves the cursor of channel, or of every channel when empty, back
+// to an earlier position
+func (r *DurableRegistry) Rewind(subject string, name string, channel string, to Cursor) (Durable, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	d, ok := r.durables[durableKey(subject, name)]
+	if !ok {
+		return Durable{}, ErrDurableNotFound
+	}
+	if channel != "" {
+		if _, ok := d.Cursors[channel]; !ok {
+			return Durable{}, ErrDurableNotFound
+		}
+		d.Cursors[channel] = to
+	} else {
+		for c := range d.Cursors {
+			d.Cursors[c] = to
+		}
+	}
+	d.Updated = time.Now()
+	r.dirty = true
+	return d.copy(), nil
+}
+
+// Delete - Removes a durable subscription and its cursors
+func (r *DurableRegistry) Delete(subject string, name string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := durableKey(subject, name)
+	if _, ok := r.durables[key]; !ok {
+		return false
+	}
+	delete(r.durables, key)
+	r.dirty = true
+	return true
+}
+
+// List - A subject's durable subscriptions by name
+func (r *DurableRegistry) List(subject string) []Durable {
+	r.lock.Lock()
+	durables := []Durable{}
+	for _, d := range r.durables {
+		if d.Subject == subject {
+			durables = append(durables, d.copy())
+		}
+	}
+	r.lock.Unlock()
+
+	sort.Slice(durables, func(i, j int) bool { return durables[i].Name < durables[j].Name })
+	return durables
+}
+
+// Flush - Saves the registry if it changed since the last save
+func (r *DurableRegistry) Flush() error {
+	r.lock.Lock()
+	if !r.dirty {
+		r.lock.Unlock()
+		return nil
+	}
+	durables := make([]Durable, 0, len(r.durables))
+	for _, d := range r.durables {
+		durables = append(durables, d.copy())
+	}
+	r.dirty = false
+	r.lock.Unlock()
+
+	if err := r.save(durables); err != nil {
+		r.lock.Lock()
+		r.dirty = true
+		r.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Run - Saves changes every DurableFlushInterval
+func (r *DurableRegistry) Run(logger *scribe.Logger) {
+	ticker := time.NewTicker(DurableFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := r.Flush(); err != nil {
+			logger.Error("core::DurableRegistry.Run => %s", err)
+		}
+	}
+}
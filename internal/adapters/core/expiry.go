@@ -0,0 +1,75 @@
+package core
+
+import (
+	"expvar"
+	"fmt"
+	"time"
+)
+
+const (
+	// ExtExpiry - Extension attribute holding the RFC 3339 time after which
+	// an event is discarded instead of delivered
+	ExtExpiry = "expiry"
+	// ExtTTL - Extension attribute holding how long an event stays
+	// deliverable after it is published, as a Go duration; converted to an
+	// expiry when the event is published
+	ExtTTL = "ttl"
+)
+
+// Expired events discarded, keyed by where they were found, published on
+// /debug/vars
+var expiredEvents = expvar.NewMap("expired_events")
+
+// StampExpiry - Resolves a ttl attribute into an absolute expiry so the
+// event's deadline does not move as it is queued and forwarded
+func StampExpiry(event CloudEvent, now time.Time) (CloudEvent, error) {
+	if _, err := expiry(event); err != nil {
+		return event, err
+	}
+
+	ttl, ok := event.Extensions[ExtTTL]
+	if !ok || event.Extensions[ExtExpiry] != "" {
+		return event, nil
+	}
+	d, err := time.ParseDuration(ttl)
+	if err != nil {
+		return event, fmt.Errorf("invalid %s: %w", ExtTTL, err)
+	}
+
+	extensions := make(map[string]string, len(event.Extensions))
+	for name, value := range event.Extensions {
+		extensions[name] = value
+	}
+	delete(extensions, ExtTTL)
+	extensions[ExtExpiry] = now.Add(d).UTC().Format(time.RFC3339Nano)
+	event.Extensions = extensions
+	return event, nil
+}
+
+func expiry(event CloudEvent) (time.Time, error) {
+	value := event.Extensions[ExtExpiry]
+	if value == "" {
+		return time.Time{}, nil
+	}
+	at, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return at, fmt.Errorf("invalid %s: %w", ExtExpiry, err)
+	}
+	return at, nil
+}
+
+// Expired - Reports whether an event's expiry has passed
+func Expired(event CloudEvent, now time.Time) bool {
+	at, err := expiry(event)
+	return err == nil && !at.IsZero() && now.After(at)
+}
+
+// DiscardExpired - Reports whether an event has expired, counting it against
+// stage when it has
+func DiscardExpired(event CloudEvent, stage string) bool {
+	if !Expired(event, time.Now()) {
+		return false
+	}
+	expiredEvents.Add(stage, 1)
+	return true
+}
@@ -0,0 +1,189 @@
+package core
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// GroupStrategy - How a consumer group member is chosen for each event
+type GroupStrategy string
+
+const (
+	// RoundRobin - Members take turns
+	RoundRobin GroupStrategy = "round-robin"
+	// LeastInFlight - The member with the fewest undelivered events, ties
+	// broken round-robin
+	LeastInFlight GroupStrategy = "least-inflight"
+)
+
+// Strategy used to balance consumer groups, GROUP_STRATEGY
+var DefaultGroupStrategy = RoundRobin
+
+func init() {
+	if strategy := GroupStrategy(os.Getenv("GROUP_STRATEGY")); strategy == LeastInFlight {
+		DefaultGroupStrategy = strategy
+	}
+}
+
+// groups - Round-robin cursors per channel and group, and the consumer groups
+// peer servers joined
+type groups struct {
+	cursors sync.Map
+	peers   map[string]map[string][]string
+	lock    sync.RWMutex
+}
+
+func newGroups() *groups {
+	return &groups{peers: make(map[string]map[string][]string)}
+}
+
+func (g *groups) next(channel string, group string) uint64 {
+	cursor, _ := g.cursors.LoadOrStore(channel+"\x00"+group, new(uint64))
+	return atomic.AddUint64(cursor.(*uint64), 1) - 1
+}
+
+// SplitGroups - Separates subscribers that receive every event from the
+// members of each consumer group
+func SplitGroups(subscribers []Subscriber) ([]Subscriber, map[string][]Subscriber) {
+	var solo []Subscriber
+	var members map[string][]Subscriber
+	for _, s := range subscribers {
+		if s.Group == "" {
+			solo = append(solo, s)
+			continue
+		}
+		if members == nil {
+			members = make(map[string][]Subscriber)
+		}
+		members[s.Group] = append(members[s.Group], s)
+	}
+	return solo, members
+}
+
+// PickMember - Index of the group member that receives the next event on
+// channel. inFlight reports a member's undelivered events and is only used
+// by LeastInFlight.
+func (adapt *Adapter) PickMember(channel string, group string, members int, inFlight func(i int) int) int {
+	if members == 0 {
+		return -1
+	}
+	start := int(adapt.groups.next(channel, group) % uint64(members))
+	if DefaultGroupStrategy != LeastInFlight || inFlight == nil {
+		return start
+	}
+
+	best, least := start, inFlight(start)
+	for n := 1; n < members && least > 0; n++ {
+		i := (start + n) % members
+		if load := inFlight(i); load < least {
+			best, least = i, load
+		}
+	}
+	return best
+}
+
+// JoinPeerGroup - Adds a peer server to a consumer group on channel
+func (adapt *Adapter) JoinPeerGroup(addr string, channel string, group string) {
+	adapt.groups.lock.Lock()
+	defer adapt.groups.lock.Unlock()
+
+	if adapt.groups.peers[channel] == nil {
+		adapt.groups.peers[channel] = make(map[string][]string)
+	}
+	for _, member := range adapt.groups.peers[channel][group] {
+		if member == addr {
+			return
+		}
+	}
+	adapt.groups.peers[channel][group] = append(adapt.groups.peers[channel][group], addr)
+}
+
+// LeavePeerGroup - Removes a peer server from a consumer group, so later
+// events go to the remaining members
+func (adapt *Adapter) LeavePeerGroup(addr string, channel string, group string) {
+	adapt.groups.lock.Lock()
+	defer adapt.groups.lock.Unlock()
+
+	members := adapt.groups.peers[channel][group]
+	for i, member := range members {
+		if member == addr {
+			adapt.groups.peers[channel][group] = append(members[:i:i], members[i+1:]...)
+			break
+		}
+	}
+	if len(adapt.groups.peers[channel][group]) == 0 {
+		delete(adapt.groups.peers[channel], group)
+	}
+	if len(adapt.groups.peers[channel]) == 0 {
+		delete(adapt.groups.peers, channel)
+	}
+}
+
+// leavePeerGroups - Removes a peer server from every consumer group it joined
+func (adapt *Adapter) leavePeerGroups(addr string) {
+	type membership struct {
+		channel string
+		group   string
+	}
+	var joined []membership
+
+	adapt.groups.lock.RLock()
+	for channel, groups := range adapt.groups.peers {
+		for group, members := range groups {
+			for _, member := range members {
+				if member == addr {
+					joined = append(joined, membership{channel, group})
+				}
+			}
+		}
+	}
+	adapt.groups.lock.RUnlock()
+
+	for _, m := range joined {
+		adapt.LeavePeerGroup(addr, m.channel, m.group)
+	}
+}
+
+// PickPeer - Peer server of a consumer group on channel that receives the
+// next event
+func (adapt *Adapter) PickPeer(channel string, group string) (string, bool) {
+	adapt.groups.lock.RLock()
+	members := adapt.groups.peers[channel][group]
+	adapt.groups.lock.RUnlock()
+
+	i := adapt.PickMember(channel, group, len(members), nil)
+	if i < 0 {
+		return "", false
+	}
+	return members[i], true
+}
+
+// PeerEvents - Fans an event out to peer servers: every peer server outside
+// the consumer groups on the event's channel, plus one member of each of them
+func (adapt *Adapter) PeerEvents(event CloudEvent) []*PeerEvent {
+	adapt.groups.lock.RLock()
+	// Grouped on other channels, a peer still gets this channel's events
+	grouped := make(map[string]bool)
+	var names []string
+	for group, members := range adapt.groups.peers[event.Type] {
+		names = append(names, group)
+		for _, member := range members {
+			grouped[member] = true
+		}
+	}
+	adapt.groups.lock.RUnlock()
+
+	var events []*PeerEvent
+	for _, addr := range adapt.GetPeerServers() {
+		if !grouped[addr] {
+			events = append(events, &PeerEvent{PeerServer: addr, Event: event})
+		}
+	}
+	for _, group := range names {
+		if addr, ok := adapt.PickPeer(event.Type, group); ok {
+			events = append(events, &PeerEvent{PeerServer: addr, Event: event, Channel: event.Type, Group: group})
+		}
+	}
+	return events
+}
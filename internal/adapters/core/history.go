@@ -0,0 +1,123 @@
+package core
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultHistoryLimit = 100
+	MaxHistoryLimit     = 1000
+)
+
+// ErrCursorExpired - The After cursor names no stored event, because it was
+// malformed or its event has been evicted from the channel's history
+var ErrCursorExpired = errors.New("history cursor expired")
+
+// EventCursor - Opaque history position of an event. CloudEvents IDs are only
+// unique per source, so the cursor carries both.
+func EventCursor(event CloudEvent) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(event.Source + "\x00" + event.ID))
+}
+
+// ParseEventCursor - Source and ID of the event an EventCursor was made for
+func ParseEventCursor(cursor string) (source string, id string, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", ErrCursorExpired
+	}
+	parts := strings.SplitN(string(raw), "\x00", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", ErrCursorExpired
+	}
+	return parts[0], parts[1], nil
+}
+
+// HistoryQuery - Selects stored events on a channel. Since and Until bound
+// the time the agent received the event, After resumes from the event with
+// that EventCursor and Last returns only the newest matching events.
+type HistoryQuery struct {
+	Since time.Time
+	Until time.Time
+	After string
+	Limit int
+	Last  int
+}
+
+// HistoryPage - Events oldest first, Next being the After cursor of the
+// following page or empty on the last page
+type HistoryPage struct {
+	Events []CloudEvent
+	Next   string
+}
+
+// HistoryMessage - Outgoing reply to a history request
+type HistoryMessage struct {
+	Type    string       `json:"type"`
+	ID      string       `json:"id,omitempty"`
+	Channel string       `json:"channel"`
+	Events  []CloudEvent `json:"events"`
+	Next    string       `json:"next,omitempty"`
+}
+
+func (h HistoryMessage) isMessage() {}
+
+// ParseHistoryQuery - Reads a query from named string parameters ("since",
+// "until" as RFC 3339, "after", "limit", "last") as sent over HTTP and WebSocket
+func ParseHistoryQuery(get func(name string) string) (HistoryQuery, error) {
+	var q HistoryQuery
+	var err error
+
+	if value := get("since"); value != "" {
+		if q.Since, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return q, fmt.Errorf("invalid since: %w", err)
+		}
+	}
+	if value := get("until"); value != "" {
+		if q.Until, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return q, fmt.Errorf("invalid until: %w", err)
+		}
+	}
+	if value := get("limit"); value != "" {
+		if q.Limit, err = strconv.Atoi(value); err != nil {
+			return q, fmt.Errorf("invalid limit: %w", err)
+		}
+	}
+	if value := get("last"); value != "" {
+		if q.Last, err = strconv.Atoi(value); err != nil {
+			return q, fmt.Errorf("invalid last: %w", err)
+		}
+	}
+	q.After = get("after")
+
+	return q.Normalize(), nil
+}
+
+// Normalize - Applies the default and maximum page size
+func (q HistoryQuery) Normalize() HistoryQuery {
+	if q.Limit <= 0 {
+		q.Limit = DefaultHistoryLimit
+	}
+	if q.Limit > MaxHistoryLimit {
+		q.Limit = MaxHistoryLimit
+	}
+	if q.Last > MaxHistoryLimit {
+		q.Last = MaxHistoryLimit
+	}
+	return q
+}
+
+// Matches - Reports whether an event received at t falls in the time range
+func (q HistoryQuery) Matches(t time.Time) bool {
+	if !q.Since.IsZero() && t.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && t.After(q.Until) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Subscriber - A client subscription to a channel
+type Subscriber struct {
+	RefID  string
+	Client string
+	// Consumer group sharing the channel's events, empty to receive them all
+	Group string
+	// Durable subscription whose cursor the subscriber advances
+	Durable string
+}
+
+// index - Channel to subscriber lookup for publish routing. Readers load an
+// immutable snapshot without locking; writers serialise on lock, copy the
+// snapshot, modify the copy and swap it in.
+type index struct {
+	snapshot atomic.Value
+	lock     sync.Mutex
+}
+
+func newIndex() *index {
+	i := &index{}
+	i.snapshot.Store(map[string][]Subscriber{})
+	return i
+}
+
+func (i *index) load() map[string][]Subscriber {
+	return i.snapshot.Load().(map[string][]Subscriber)
+}
+
+// subscribers - Lock free lookup, the returned slice must not be modified
+func (i *index) subscribers(channel string) []Subscriber {
+	return i.load()[channel]
+}
+
+func (i *index) add(channel string, s Subscriber) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	current := i.load()
+	next := make(map[string][]Subscriber, len(current)+1)
+	for k, v := range current {
+		next[k] = v
+	}
+
+	subscribers := make([]Subscriber, len(current[channel]), len(current[channel])+1)
+	copy(subscribers, current[channel])
+	next[channel] = append(subscribers, s)
+
+	i.snapshot.Store(next)
+}
+
+// remove - Drops the subscribers on channel for which drop returns true
+func (i *index) remove(channel string, drop func(Subscriber) bool) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	current := i.load()
+	existing, ok := current[channel]
+	if !ok {
+		return
+	}
+
+	subscribers := make([]Subscriber, 0, len(existing))
+	for _, s := range existing {
+		if !drop(s) {
+			subscribers = append(subscribers, s)
+		}
+	}
+	if len(subscribers) == len(existing) {
+		return
+	}
+
+	next := make(map[string][]Subscriber, len(current))
+	for k, v := range current {
+		next[k] = v
+	}
+	if len(subscribers) == 0 {
+		delete(next, channel)
+	} else {
+		next[channel] = subscribers
+	}
+
+	i.snapshot.Store(next)
+}
+
+// removeClient - Drops all of a client's subscriptions on every channel
+func (i *index) removeClient(client string) {
+	for channel := range i.load() {
+		i.remove(channel, func(s Subscriber) bool { return s.Client == client })
+	}
+}
@@ -0,0 +1,623 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// unsupportedKeywords - Assertion and applicator keywords this validator
+// does not implement. Schemas using them fail to compile rather than being
+// enforced only in part.
+var unsupportedKeywords = []string{
+	"if", "then", "else",
+	"contains", "minContains", "maxContains",
+	"dependentRequired", "dependentSchemas", "dependencies",
+	"propertyNames", "additionalItems",
+	"unevaluatedProperties", "unevaluatedItems",
+	"$dynamicRef", "$recursiveRef",
+}
+
+// jsonSchema - Compiled JSON Schema covering the validation keywords listed
+// in the README. Other keywords, format included, are ignored as the
+// specification allows for annotations, except unsupportedKeywords.
+type jsonSchema struct {
+	reject bool
+	ref    *jsonSchema
+
+	types    []string
+	enum     []interface{}
+	hasConst bool
+	constant interface{}
+
+	properties        map[string]*jsonSchema
+	patternProperties []patternSchema
+	additional        *jsonSchema
+	required          []string
+	minProperties     *int
+	maxProperties     *int
+
+	prefixItems []*jsonSchema
+	items       *jsonSchema
+	minItems    *int
+	maxItems    *int
+	uniqueItems bool
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       *float64
+
+	allOf []*jsonSchema
+	anyOf []*jsonSchema
+	oneOf []*jsonSchema
+	not   *jsonSchema
+}
+
+type patternSchema struct {
+	pattern *regexp.Regexp
+	schema  *jsonSchema
+}
+
+// schemaCompiler - Compiles one schema document, resolving local $refs once
+// so recursive schemas share a node
+type schemaCompiler struct {
+	root interface{}
+	refs map[string]*jsonSchema
+}
+
+// compileSchema - Parses and compiles a JSON Schema document, failing on
+// malformed or unsupported keywords, invalid patterns and $refs that do not
+// resolve
+func compileSchema(document []byte) (*jsonSchema, error) {
+	var root interface{}
+	if err := json.Unmarshal(document, &root); err != nil {
+		return nil, err
+	}
+	c := &schemaCompiler{root: root, refs: make(map[string]*jsonSchema)}
+	schema, err := c.compile(root, "#")
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCycles(schema); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// inPlace - Subschemas applied to the same value as s
+func (s *jsonSchema) inPlace() []*jsonSchema {
+	var children []*jsonSchema
+	if s.ref != nil {
+		children = append(children, s.ref)
+	}
+	if s.not != nil {
+		children = append(children, s.not)
+	}
+	children = append(children, s.allOf...)
+	children = append(children, s.anyOf...)
+	return append(children, s.oneOf...)
+}
+
+// nested - Subschemas applied to the values inside s's value
+func (s *jsonSchema) nested() []*jsonSchema {
+	var children []*jsonSchema
+	for _, schema := range s.properties {
+		children = append(children, schema)
+	}
+	for _, p := range s.patternProperties {
+		children = append(children, p.schema)
+	}
+	if s.additional != nil {
+		children = append(children, s.additional)
+	}
+	if s.items != nil {
+		children = append(children, s.items)
+	}
+	return append(children, s.prefixItems...)
+}
+
+// checkCycles - Rejects schemas that reach themselves without descending
+// into the data, which validation would follow forever
+func checkCycles(root *jsonSchema) error {
+	var all []*jsonSchema
+	seen := map[*jsonSchema]bool{}
+	var collect func(s *jsonSchema)
+	collect = func(s *jsonSchema) {
+		if seen[s] {
+			return
+		}
+		seen[s] = true
+		all = append(all, s)
+		for _, child := range append(s.inPlace(), s.nested()...) {
+			collect(child)
+		}
+	}
+	collect(root)
+
+	const visiting, done = 1, 2
+	state := map[*jsonSchema]int{}
+	var visit func(s *jsonSchema) error
+	visit = func(s *jsonSchema) error {
+		switch state[s] {
+		case visiting:
+			return errors.New("schema references itself without descending into the data")
+		case done:
+			return nil
+		}
+		state[s] = visiting
+		for _, child := range s.inPlace() {
+			if err := visit(child); err != nil {
+				return err
+			}
+		}
+		state[s] = done
+		return nil
+	}
+	for _, s := range all {
+		if err := visit(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *schemaCompiler) compile(node interface{}, at string) (*jsonSchema, error) {
+	if b, ok := node.(bool); ok {
+		return &jsonSchema{reject: !b}, nil
+	}
+	n, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or boolean", at)
+	}
+
+	for _, keyword := range unsupportedKeywords {
+		if _, ok := n[keyword]; ok {
+			return nil, fmt.Errorf("%s/%s: keyword is not supported", at, keyword)
+		}
+	}
+
+	s := &jsonSchema{}
+	var err error
+
+	if ref, ok := n["$ref"].(string); ok {
+		if s.ref, err = c.resolve(ref); err != nil {
+			return nil, fmt.Errorf("%s: %w", at, err)
+		}
+	}
+
+	switch t := n["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, name := range t {
+			name, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/type: must be a string or array of strings", at)
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, fmt.Errorf("%s/type: must be a string or array of strings", at)
+	}
+
+	if enum, ok := n["enum"]; ok {
+		if s.enum, ok = enum.([]interface{}); !ok {
+			return nil, fmt.Errorf("%s/enum: must be an array", at)
+		}
+	}
+	if constant, ok := n["const"]; ok {
+		s.hasConst, s.constant = true, constant
+	}
+
+	if properties, ok := n["properties"]; ok {
+		props, ok := properties.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/properties: must be an object", at)
+		}
+		s.properties = make(map[string]*jsonSchema, len(props))
+		for name, prop := range props {
+			if s.properties[name], err = c.compile(prop, at+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if patterns, ok := n["patternProperties"]; ok {
+		props, ok := patterns.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/patternProperties: must be an object", at)
+		}
+		for pattern, prop := range props {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s/patternProperties: %w", at, err)
+			}
+			schema, err := c.compile(prop, at+"/patternProperties/"+pattern)
+			if err != nil {
+				return nil, err
+			}
+			s.patternProperties = append(s.patternProperties, patternSchema{pattern: re, schema: schema})
+		}
+	}
+	if additional, ok := n["additionalProperties"]; ok {
+		if s.additional, err = c.compile(additional, at+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if required, ok := n["required"]; ok {
+		names, ok := required.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/required: must be an array of strings", at)
+		}
+		for _, name := range names {
+			name, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/required: must be an array of strings", at)
+			}
+			s.required = append(s.required, name)
+		}
+	}
+
+	// Draft 2020-12 prefixItems, or the array form of items in older drafts
+	prefix, _ := n["prefixItems"].([]interface{})
+	switch items := n["items"].(type) {
+	case nil:
+	case []interface{}:
+		prefix = items
+	default:
+		if s.items, err = c.compile(items, at+"/items"); err != nil {
+			return nil, err
+		}
+	}
+	if s.prefixItems, err = c.compileList(prefix, at+"/prefixItems"); err != nil {
+		return nil, err
+	}
+	s.uniqueItems, _ = n["uniqueItems"].(bool)
+
+	for keyword, target := range map[string]**int{
+		"minProperties": &s.minProperties,
+		"maxProperties": &s.maxProperties,
+		"minItems":      &s.minItems,
+		"maxItems":      &s.maxItems,
+		"minLength":     &s.minLength,
+		"maxLength":     &s.maxLength,
+	} {
+		value, ok := n[keyword]
+		if !ok {
+			continue
+		}
+		count, ok := value.(float64)
+		if !ok || count < 0 || count != math.Trunc(count) {
+			return nil, fmt.Errorf("%s/%s: must be a non-negative integer", at, keyword)
+		}
+		limit := int(count)
+		*target = &limit
+	}
+
+	for keyword, target := range map[string]**float64{
+		"minimum":          &s.minimum,
+		"maximum":          &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum,
+		"exclusiveMaximum": &s.exclusiveMaximum,
+		"multipleOf":       &s.multipleOf,
+	} {
+		value, ok := n[keyword]
+		if !ok {
+			continue
+		}
+		number, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%s/%s: must be a number", at, keyword)
+		}
+		*target = &number
+	}
+	if s.multipleOf != nil && *s.multipleOf <= 0 {
+		return nil, fmt.Errorf("%s/multipleOf: must be greater than 0", at)
+	}
+
+	if pattern, ok := n["pattern"]; ok {
+		p, ok := pattern.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s/pattern: must be a string", at)
+		}
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("%s/pattern: %w", at, err)
+		}
+	}
+
+	for keyword, target := range map[string]*[]*jsonSchema{
+		"allOf": &s.allOf,
+		"anyOf": &s.anyOf,
+		"oneOf": &s.oneOf,
+	} {
+		value, ok := n[keyword]
+		if !ok {
+			continue
+		}
+		list, ok := value.([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("%s/%s: must be a non-empty array", at, keyword)
+		}
+		if *target, err = c.compileList(list, at+"/"+keyword); err != nil {
+			return nil, err
+		}
+	}
+	if not, ok := n["not"]; ok {
+		if s.not, err = c.compile(not, at+"/not"); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (c *schemaCompiler) compileList(nodes []interface{}, at string) ([]*jsonSchema, error) {
+	schemas := make([]*jsonSchema, len(nodes))
+	for i, node := range nodes {
+		schema, err := c.compile(node, at+"/"+strconv.Itoa(i))
+		if err != nil {
+			return nil, err
+		}
+		schemas[i] = schema
+	}
+	return schemas, nil
+}
+
+// resolve - Compiles the target of a $ref within the same document, such as
+// #/$defs/price
+func (c *schemaCompiler) resolve(ref string) (*jsonSchema, error) {
+	if schema, ok := c.refs[ref]; ok {
+		return schema, nil
+	}
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("$ref %q: only references within the document are supported", ref)
+	}
+	if pointer := strings.TrimPrefix(ref, "#"); pointer != "" && !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("$ref %q: only JSON pointers are supported, not anchors", ref)
+	}
+
+	target := c.root
+	if pointer := strings.TrimPrefix(ref, "#"); pointer != "" {
+		for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+			token, err := url.PathUnescape(token)
+			if err != nil {
+				return nil, fmt.Errorf("$ref %q: %w", ref, err)
+			}
+			token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+			switch node := target.(type) {
+			case map[string]interface{}:
+				target = node[token]
+			case []interface{}:
+				i, err := strconv.Atoi(token)
+				if err != nil || i < 0 || i >= len(node) {
+					return nil, fmt.Errorf("$ref %q does not resolve", ref)
+				}
+				target = node[i]
+			default:
+				target = nil
+			}
+			if target == nil {
+				return nil, fmt.Errorf("$ref %q does not resolve", ref)
+			}
+		}
+	}
+
+	// Registered before compiling so recursive references find it
+	schema := &jsonSchema{}
+	c.refs[ref] = schema
+	compiled, err := c.compile(target, ref)
+	if err != nil {
+		return nil, err
+	}
+	*schema = *compiled
+	return schema, nil
+}
+
+// validate - Appends a violation for every keyword value breaks, path being
+// the JSON pointer of value within the data
+func (s *jsonSchema) validate(value interface{}, path string, violations *[]Violation) {
+	violate := func(reason string) {
+		*violations = append(*violations, Violation{Attribute: "data" + path, Reason: reason})
+	}
+
+	if s.reject {
+		violate("is not allowed")
+		return
+	}
+	if s.ref != nil {
+		s.ref.validate(value, path, violations)
+	}
+	if len(s.types) > 0 && !matchesType(value, s.types) {
+		violate("must be " + strings.Join(s.types, " or "))
+		return
+	}
+	if s.enum != nil && !containsJSON(s.enum, value) {
+		violate("must be one of the enumerated values")
+	}
+	if s.hasConst && !reflect.DeepEqual(s.constant, value) {
+		constant, _ := json.Marshal(s.constant)
+		violate("must equal " + string(constant))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(v, path, violations)
+	case []interface{}:
+		s.validateArray(v, path, violations)
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.minLength != nil && length < *s.minLength {
+			violate(fmt.Sprintf("must be at least %d characters", *s.minLength))
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			violate(fmt.Sprintf("must be at most %d characters", *s.maxLength))
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			violate(fmt.Sprintf("must match pattern %q", s.pattern.String()))
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			violate(fmt.Sprintf("must be >= %v", *s.minimum))
+		}
+		if s.maximum != nil && v > *s.maximum {
+			violate(fmt.Sprintf("must be <= %v", *s.maximum))
+		}
+		if s.exclusiveMinimum != nil && v <= *s.exclusiveMinimum {
+			violate(fmt.Sprintf("must be > %v", *s.exclusiveMinimum))
+		}
+		if s.exclusiveMaximum != nil && v >= *s.exclusiveMaximum {
+			violate(fmt.Sprintf("must be < %v", *s.exclusiveMaximum))
+		}
+		if s.multipleOf != nil {
+			if q := v / *s.multipleOf; q != math.Trunc(q) {
+				violate(fmt.Sprintf("must be a multiple of %v", *s.multipleOf))
+			}
+		}
+	}
+
+	for _, schema := range s.allOf {
+		schema.validate(value, path, violations)
+	}
+	if len(s.anyOf) > 0 && s.countMatches(s.anyOf, value, path) == 0 {
+		violate("must match at least one schema in anyOf")
+	}
+	if len(s.oneOf) > 0 {
+		if matched := s.countMatches(s.oneOf, value, path); matched != 1 {
+			violate(fmt.Sprintf("must match exactly one schema in oneOf, matched %d", matched))
+		}
+	}
+	if s.not != nil && s.countMatches([]*jsonSchema{s.not}, value, path) == 1 {
+		violate("must not match the schema in not")
+	}
+}
+
+func (s *jsonSchema) validateObject(object map[string]interface{}, path string, violations *[]Violation) {
+	for _, name := range s.required {
+		if _, ok := object[name]; !ok {
+			*violations = append(*violations, Violation{Attribute: "data" + path, Reason: fmt.Sprintf("is missing required property %q", name)})
+		}
+	}
+	if s.minProperties != nil && len(object) < *s.minProperties {
+		*violations = append(*violations, Violation{Attribute: "data" + path, Reason: fmt.Sprintf("must have at least %d properties", *s.minProperties)})
+	}
+	if s.maxProperties != nil && len(object) > *s.maxProperties {
+		*violations = append(*violations, Violation{Attribute: "data" + path, Reason: fmt.Sprintf("must have at most %d properties", *s.maxProperties)})
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		at := path + "/" + strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+		matched := false
+		if schema, ok := s.properties[name]; ok {
+			schema.validate(object[name], at, violations)
+			matched = true
+		}
+		for _, p := range s.patternProperties {
+			if p.pattern.MatchString(name) {
+				p.schema.validate(object[name], at, violations)
+				matched = true
+			}
+		}
+		if !matched && s.additional != nil {
+			s.additional.validate(object[name], at, violations)
+		}
+	}
+}
+
+func (s *jsonSchema) validateArray(array []interface{}, path string, violations *[]Violation) {
+	if s.minItems != nil && len(array) < *s.minItems {
+		*violations = append(*violations, Violation{Attribute: "data" + path, Reason: fmt.Sprintf("must have at least %d items", *s.minItems)})
+	}
+	if s.maxItems != nil && len(array) > *s.maxItems {
+		*violations = append(*violations, Violation{Attribute: "data" + path, Reason: fmt.Sprintf("must have at most %d items", *s.maxItems)})
+	}
+	if s.uniqueItems {
+		for i := range array {
+			if containsJSON(array[:i], array[i]) {
+				*violations = append(*violations, Violation{Attribute: "data" + path, Reason: "must have unique items"})
+				break
+			}
+		}
+	}
+	for i, item := range array {
+		at := path + "/" + strconv.Itoa(i)
+		if i < len(s.prefixItems) {
+			s.prefixItems[i].validate(item, at, violations)
+		} else if s.items != nil {
+			s.items.validate(item, at, violations)
+		}
+	}
+}
+
+// countMatches - Number of schemas value fully conforms to
+func (s *jsonSchema) countMatches(schemas []*jsonSchema, value interface{}, path string) int {
+	matched := 0
+	for _, schema := range schemas {
+		var violations []Violation
+		schema.validate(value, path, &violations)
+		if len(violations) == 0 {
+			matched++
+		}
+	}
+	return matched
+}
+
+func matchesType(value interface{}, types []string) bool {
+	for _, t := range types {
+		switch v := value.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case float64:
+			if t == "number" || (t == "integer" && v == math.Trunc(v)) {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsJSON(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
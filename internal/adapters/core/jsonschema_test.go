@@ -0,0 +1,101 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// schemaSuiteGroup - Test case group in the layout of the JSON Schema Test
+// Suite, https://github.com/json-schema-org/JSON-Schema-Test-Suite
+type schemaSuiteGroup struct {
+	Description string          `json:"description"`
+	Schema      json.RawMessage `json:"schema"`
+	Tests       []struct {
+		Description string          `json:"description"`
+		Data        json.RawMessage `json:"data"`
+		Valid       bool            `json:"valid"`
+	} `json:"tests"`
+}
+
+// TestSchemaSuite - Runs the suite cases in testdata/jsonschema, one file per
+// supported keyword as in the suite's draft2020-12 directory
+func TestSchemaSuite(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "jsonschema", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no suite files in testdata/jsonschema")
+	}
+
+	for _, file := range files {
+		document, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var groups []schemaSuiteGroup
+		if err := json.Unmarshal(document, &groups); err != nil {
+			t.Fatalf("%s: %s", file, err)
+		}
+
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".json"), func(t *testing.T) {
+			for _, group := range groups {
+				schema, err := compileSchema(group.Schema)
+				if err != nil {
+					t.Errorf("%s: compile: %s", group.Description, err)
+					continue
+				}
+				for _, test := range group.Tests {
+					var data interface{}
+					if err := json.Unmarshal(test.Data, &data); err != nil {
+						t.Fatalf("%s / %s: %s", group.Description, test.Description, err)
+					}
+					var violations []Violation
+					schema.validate(data, "", &violations)
+					if valid := len(violations) == 0; valid != test.Valid {
+						t.Errorf("%s / %s: valid = %t, want %t %v", group.Description, test.Description, valid, test.Valid, violations)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCompileSchemaRejectsUnsupported(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{"if", `{"if": {"type": "string"}, "then": {"minLength": 1}}`},
+		{"else", `{"else": false}`},
+		{"contains", `{"contains": {"const": 1}}`},
+		{"minContains", `{"minContains": 2}`},
+		{"dependentRequired", `{"dependentRequired": {"a": ["b"]}}`},
+		{"dependentSchemas", `{"dependentSchemas": {"a": {"required": ["b"]}}}`},
+		{"dependencies", `{"dependencies": {"a": ["b"]}}`},
+		{"propertyNames", `{"propertyNames": {"maxLength": 3}}`},
+		{"additionalItems", `{"items": [{}], "additionalItems": false}`},
+		{"unevaluatedProperties", `{"unevaluatedProperties": false}`},
+		{"unevaluatedItems", `{"unevaluatedItems": false}`},
+		{"nested", `{"properties": {"a": {"contains": {"const": 1}}}}`},
+		{"anchor", `{"$defs": {"a": {"$anchor": "a"}}, "$ref": "#a"}`},
+		{"remote ref", `{"$ref": "https://example.com/schema.json"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := compileSchema([]byte(test.schema)); err == nil {
+				t.Errorf("compileSchema(%s) succeeded, want an error", test.schema)
+			}
+		})
+	}
+}
+
+func TestCompileSchemaIgnoresAnnotations(t *testing.T) {
+	schema := `{"title": "order", "description": "an order", "format": "uuid", "examples": [1], "properties": {"if": {"type": "string"}}}`
+	if _, err := compileSchema([]byte(schema)); err != nil {
+		t.Errorf("compileSchema(%s): %s", schema, err)
+	}
+}
@@ -0,0 +1,30 @@
+package core
+
+import "hash/fnv"
+
+// ExtPartitionKey - Extension attribute naming the key events are ordered by,
+// defaulting to the event's subject
+const ExtPartitionKey = "partitionkey"
+
+// PartitionKey - Key whose events are delivered in publish order. Events
+// without a key or subject are keyed by their ID, so they spread across
+// lanes instead of sharing one.
+func PartitionKey(event CloudEvent) string {
+	if key, ok := event.Extensions[ExtPartitionKey]; ok && key != "" {
+		return key
+	}
+	if event.Subject != "" && event.Subject != "*" {
+		return event.Subject
+	}
+	return event.ID
+}
+
+// Partition - Which of n ordered lanes handles key
+func Partition(key string, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
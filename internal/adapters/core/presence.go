@@ -0,0 +1,111 @@
+package core
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Channels starting with PresencePrefix track their members,
+// PRESENCE_CHANNEL_PREFIX
+var PresencePrefix = "presence."
+
+func init() {
+	if prefix, ok := os.LookupEnv("PRESENCE_CHANNEL_PREFIX"); ok && prefix != "" {
+		PresencePrefix = prefix
+	}
+}
+
+const (
+	PresenceJoin  = "presence.join"
+	PresenceLeave = "presence.leave"
+)
+
+// Member - A connection present on a channel, identified by its token subject
+// with optional metadata supplied by the client when subscribing
+type Member struct {
+	ID       string                 `json:"id"`
+	Subject  string                 `json:"subject"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+	JoinedAt time.Time              `json:"joinedAt"`
+}
+
+// PresenceMessage - Outgoing join or leave notification sent to the other
+// members of a presence channel
+type PresenceMessage struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+	Member  Member `json:"member"`
+}
+
+func (p PresenceMessage) isMessage() {}
+
+// MembersMessage - Outgoing reply to a members request
+type MembersMessage struct {
+	Type    string   `json:"type"`
+	ID      string   `json:"id,omitempty"`
+	Channel string   `json:"channel"`
+	Members []Member `json:"members"`
+}
+
+func (m MembersMessage) isMessage() {}
+
+// IsPresenceChannel - Reports whether members of channel are tracked
+func IsPresenceChannel(channel string) bool {
+	return strings.HasPrefix(channel, PresencePrefix)
+}
+
+// Join - Adds member to a presence channel, returning false when the
+// connection was already present
+func (adapt *Adapter) Join(channel string, member Member) bool {
+	s := adapt.shardFor(channel)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.presence[channel][member.ID]; ok {
+		return false
+	}
+	if s.presence[channel] == nil {
+		s.presence[channel] = make(map[string]Member)
+	}
+	if member.JoinedAt.IsZero() {
+		member.JoinedAt = time.Now()
+	}
+	s.presence[channel][member.ID] = member
+	return true
+}
+
+// Leave - Removes a connection from a presence channel, returning the member
+// that left
+func (adapt *Adapter) Leave(channel string, id string) (Member, bool) {
+	s := adapt.shardFor(channel)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	member, ok := s.presence[channel][id]
+	if !ok {
+		return Member{}, false
+	}
+	delete(s.presence[channel], id)
+	if len(s.presence[channel]) == 0 {
+		delete(s.presence, channel)
+	}
+	return member, true
+}
+
+// Members - Members of a presence channel in the order they joined
+func (adapt *Adapter) Members(channel string) []Member {
+	s := adapt.shardFor(channel)
+	s.lock.RLock()
+	members := make([]Member, 0, len(s.presence[channel]))
+	for _, member := range s.presence[channel] {
+		members = append(members, member)
+	}
+	s.lock.RUnlock()
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].JoinedAt.Before(members[j].JoinedAt)
+	})
+	return members
+}
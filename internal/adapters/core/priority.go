@@ -0,0 +1,93 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Priority - Scheduling class of an event, lower values are served first
+type Priority int
+
+const (
+	PriorityHigh Priority = iota
+	PriorityNormal
+	PriorityLow
+
+	// PriorityLevels - Number of priority classes, each with its own queue
+	PriorityLevels = 3
+
+	// ExtPriority - Extension attribute holding "high", "normal" or "low"
+	ExtPriority = "priority"
+)
+
+// Share of turns each priority gets while several have events waiting,
+// PRIORITY_WEIGHTS as "high:normal:low"
+var PriorityWeights = [PriorityLevels]int{8, 4, 1}
+
+func init() {
+	if value := os.Getenv("PRIORITY_WEIGHTS"); value != "" {
+		weights, err := ParsePriorityWeights(value)
+		if err != nil {
+			panic(err)
+		}
+		PriorityWeights = weights
+	}
+}
+
+// ParsePriorityWeights - Reads "high:normal:low" weights, each at least 1 so
+// no priority is starved
+func ParsePriorityWeights(value string) ([PriorityLevels]int, error) {
+	var weights [PriorityLevels]int
+	parts := strings.Split(value, ":")
+	if len(parts) != PriorityLevels {
+		return weights, fmt.Errorf("priority weights '%s' must be high:normal:low", value)
+	}
+	for i, part := range parts {
+		weight, err := strconv.Atoi(part)
+		if err != nil || weight < 1 {
+			return weights, fmt.Errorf("invalid priority weight '%s'", part)
+		}
+		weights[i] = weight
+	}
+	return weights, nil
+}
+
+// PriorityOf - Priority an event asked for, normal when unset or unknown
+func PriorityOf(event CloudEvent) Priority {
+	switch event.Extensions[ExtPriority] {
+	case "high":
+		return PriorityHigh
+	case "low":
+		return PriorityLow
+	}
+	return PriorityNormal
+}
+
+// WeightedLanes - Smooth weighted round-robin over the priority queues: with
+// several queues waiting each is picked in proportion to its weight,
+// interleaved rather than in bursts. Not safe for concurrent use.
+type WeightedLanes struct {
+	current [PriorityLevels]int
+}
+
+// Next - Picks among the priorities for which ready returns true, or -1 when
+// none are
+func (w *WeightedLanes) Next(ready func(p Priority) bool) Priority {
+	best, total := Priority(-1), 0
+	for p := PriorityHigh; p < PriorityLevels; p++ {
+		if !ready(p) {
+			continue
+		}
+		w.current[p] += PriorityWeights[p]
+		total += PriorityWeights[p]
+		if best < 0 || w.current[p] > w.current[best] {
+			best = p
+		}
+	}
+	if best >= 0 {
+		w.current[best] -= total
+	}
+	return best
+}
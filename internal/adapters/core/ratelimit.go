@@ -0,0 +1,292 @@
+package core
+
+import (
+	"expvar"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rejected operations keyed by "<op>:<scope>", published on /debug/vars
+var rateLimitRejections = expvar.NewMap("rate_limit_rejections")
+
+// Rate - Token bucket refill rate and burst size. The zero Rate is unlimited.
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// ParseRate - Parses "<per second>:<burst>", burst defaulting to the rate
+func ParseRate(value string) (Rate, error) {
+	if value == "" {
+		return Rate{}, nil
+	}
+
+	parts := strings.SplitN(value, ":", 2)
+	perSecond, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return Rate{}, fmt.Errorf("invalid rate '%s': %w", value, err)
+	}
+
+	burst := int(perSecond)
+	if len(parts) == 2 {
+		if burst, err = strconv.Atoi(parts[1]); err != nil {
+			return Rate{}, fmt.Errorf("invalid burst '%s': %w", value, err)
+		}
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return Rate{PerSecond: perSecond, Burst: burst}, nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter - Token buckets keyed by connection, subject or channel
+type RateLimiter struct {
+	rate      Rate
+	buckets   map[string]*bucket
+	lastPrune time.Time
+	lock      sync.Mutex
+}
+
+func NewRateLimiter(rate Rate) *RateLimiter {
+	if rate.PerSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		rate:      rate,
+		buckets:   make(map[string]*bucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// Allow - Takes a token from key's bucket, reporting false when empty
+func (l *RateLimiter) Allow(key string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	b := l.refill(key)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Available - Reports whether key's bucket holds n tokens without taking them
+func (l *RateLimiter) Available(key string, n int) bool {
+	if l == nil {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.refill(key).tokens >= float64(n)
+}
+
+// fits - Reports whether a bucket can ever hold n tokens
+func (l *RateLimiter) fits(n int) bool {
+	return l == nil || n <= l.rate.Burst
+}
+
+// Take - Takes n tokens from key's bucket. A bucket drained by a concurrent
+// caller since Available goes into debt and refills from below zero.
+func (l *RateLimiter) Take(key string, n int) {
+	if l == nil {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.refill(key).tokens -= float64(n)
+}
+
+// refill - Key's bucket topped up for the time since it was last used.
+// Caller holds the lock.
+func (l *RateLimiter) refill(key string) *bucket {
+	now := time.Now()
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.rate.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate.PerSecond
+	if b.tokens > float64(l.rate.Burst) {
+		b.tokens = float64(l.rate.Burst)
+	}
+	b.last = now
+	return b
+}
+
+// Forget - Drops key's bucket
+func (l *RateLimiter) Forget(key string) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.buckets, key)
+}
+
+// prune - Drops buckets idle long enough to have refilled, at most once a minute
+func (l *RateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	full := time.Duration(float64(l.rate.Burst) / l.rate.PerSecond * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type scopedLimiters struct {
+	connection *RateLimiter
+	subject    *RateLimiter
+	channel    *RateLimiter
+}
+
+// allow - Charges one token per channel to the connection and subject and
+// one to each channel, only once every bucket is known to hold enough, so a
+// rejected request costs nothing
+func (s scopedLimiters) allow(op string, connection string, subject string, channels ...string) bool {
+	perChannel := make(map[string]int, len(channels))
+	for _, channel := range channels {
+		perChannel[channel]++
+	}
+
+	if connection != "" && !s.connection.Available(connection, len(channels)) {
+		rateLimitRejections.Add(op+":connection", 1)
+		return false
+	}
+	if subject != "" && !s.subject.Available(subject, len(channels)) {
+		rateLimitRejections.Add(op+":subject", 1)
+		return false
+	}
+	for channel, n := range perChannel {
+		if !s.channel.Available(channel, n) {
+			rateLimitRejections.Add(op+":channel", 1)
+			return false
+		}
+	}
+
+	if connection != "" {
+		s.connection.Take(connection, len(channels))
+	}
+	if subject != "" {
+		s.subject.Take(subject, len(channels))
+	}
+	for channel, n := range perChannel {
+		s.channel.Take(channel, n)
+	}
+	return true
+}
+
+// fits - Reports whether a request for channels asks no bucket for more than
+// its burst, which allow could never grant
+func (s scopedLimiters) fits(channels ...string) bool {
+	if !s.connection.fits(len(channels)) || !s.subject.fits(len(channels)) {
+		return false
+	}
+	perChannel := make(map[string]int, len(channels))
+	for _, channel := range channels {
+		perChannel[channel]++
+		if !s.channel.fits(perChannel[channel]) {
+			return false
+		}
+	}
+	return true
+}
+
+// RatePolicy - Publish and subscribe limits per connection, token subject and channel
+type RatePolicy struct {
+	PublishConnection   Rate
+	PublishSubject      Rate
+	PublishChannel      Rate
+	SubscribeConnection Rate
+	SubscribeSubject    Rate
+	SubscribeChannel    Rate
+}
+
+// LoadRatePolicy - Reads RATELIMIT_{PUBLISH,SUBSCRIBE}_{CONNECTION,SUBJECT,CHANNEL}
+func LoadRatePolicy() (RatePolicy, error) {
+	var policy RatePolicy
+	for env, rate := range map[string]*Rate{
+		"RATELIMIT_PUBLISH_CONNECTION":   &policy.PublishConnection,
+		"RATELIMIT_PUBLISH_SUBJECT":      &policy.PublishSubject,
+		"RATELIMIT_PUBLISH_CHANNEL":      &policy.PublishChannel,
+		"RATELIMIT_SUBSCRIBE_CONNECTION": &policy.SubscribeConnection,
+		"RATELIMIT_SUBSCRIBE_SUBJECT":    &policy.SubscribeSubject,
+		"RATELIMIT_SUBSCRIBE_CHANNEL":    &policy.SubscribeChannel,
+	} {
+		parsed, err := ParseRate(os.Getenv(env))
+		if err != nil {
+			return policy, fmt.Errorf("%s: %w", env, err)
+		}
+		*rate = parsed
+	}
+	return policy, nil
+}
+
+// Limits - Rate limits shared by every transport
+type Limits struct {
+	publish   scopedLimiters
+	subscribe scopedLimiters
+}
+
+func NewLimits(policy RatePolicy) *Limits {
+	return &Limits{
+		publish: scopedLimiters{
+			connection: NewRateLimiter(policy.PublishConnection),
+			subject:    NewRateLimiter(policy.PublishSubject),
+			channel:    NewRateLimiter(policy.PublishChannel),
+		},
+		subscribe: scopedLimiters{
+			connection: NewRateLimiter(policy.SubscribeConnection),
+			subject:    NewRateLimiter(policy.SubscribeSubject),
+			channel:    NewRateLimiter(policy.SubscribeChannel),
+		},
+	}
+}
+
+// AllowPublish - Checks publish limits, connection is empty for transports
+// without persistent connections
+func (l *Limits) AllowPublish(connection string, subject string, channel string) bool {
+	return l.publish.allow("publish", connection, subject, channel)
+}
+
+// AllowSubscribe - Checks subscribe limits for every channel of a request,
+// charging nothing unless all are allowed
+func (l *Limits) AllowSubscribe(connection string, subject string, channels ...string) bool {
+	return l.subscribe.allow("subscribe", connection, subject, channels...)
+}
+
+// SubscribeFits - Reports whether a subscribe to channels is within the
+// subscribe bursts. Larger requests are malformed rather than rate limited,
+// as waiting would never let them through.
+func (l *Limits) SubscribeFits(channels ...string) bool {
+	return l.subscribe.fits(channels...)
+}
+
+// Forget - Releases a closed connection's buckets
+func (l *Limits) Forget(connection string) {
+	l.publish.connection.Forget(connection)
+	l.subscribe.connection.Forget(connection)
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrNoPendingRequest = errors.New("request already answered or timed out")
+
+var (
+	// Wait for a reply when a request names no timeout, REQUEST_TIMEOUT
+	DefaultRequestTimeout = 5 * time.Second
+	// Longest a request may wait for a reply, REQUEST_MAX_TIMEOUT
+	MaxRequestTimeout = time.Minute
+)
+
+func init() {
+	if timeout, err := time.ParseDuration(os.Getenv("REQUEST_TIMEOUT")); err == nil && timeout > 0 {
+		DefaultRequestTimeout = timeout
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("REQUEST_MAX_TIMEOUT")); err == nil && timeout > 0 {
+		MaxRequestTimeout = timeout
+	}
+}
+
+// ExtReplyTo - Extension attribute holding the inbox a request's reply is
+// routed to
+const ExtReplyTo = "replyto"
+
+const (
+	AckReplied     = "replied"
+	ErrCodeTimeout = "timeout"
+)
+
+// RequestMessage - Outgoing request delivered to the subscribers of a
+// channel, answered with a reply frame naming ReplyTo
+type RequestMessage struct {
+	Type    string     `json:"type"`
+	Channel string     `json:"channel"`
+	ReplyTo string     `json:"replyTo"`
+	Event   CloudEvent `json:"event"`
+}
+
+// ReplyMessage - Outgoing reply to a request, keyed by the request's
+// correlation ID
+type ReplyMessage struct {
+	Type  string     `json:"type"`
+	ID    string     `json:"id,omitempty"`
+	Event CloudEvent `json:"event"`
+}
+
+func (r RequestMessage) isMessage() {}
+
+func (r ReplyMessage) isMessage() {}
+
+// RequestTimeout - Clamps a requested timeout, zero selecting the default
+func RequestTimeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return DefaultRequestTimeout
+	}
+	if timeout > MaxRequestTimeout {
+		return MaxRequestTimeout
+	}
+	return timeout
+}
+
+type pendingReply struct {
+	channel string
+	resolve func(event CloudEvent, ok bool)
+	timer   *time.Timer
+}
+
+// replies - Requests waiting for their first reply
+type replies struct {
+	pending map[string]*pendingReply
+	lock    sync.Mutex
+}
+
+func newReplies() *replies {
+	return &replies{pending: make(map[string]*pendingReply)}
+}
+
+func (r *replies) take(replyTo string) *pendingReply {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	pending, ok := r.pending[replyTo]
+	if !ok {
+		return nil
+	}
+	delete(r.pending, replyTo)
+	return pending
+}
+
+// ExpectReply - Registers a request sent on channel and returns its reply
+// inbox. resolve is called exactly once, with the first reply or with ok
+// false after timeout.
+func (adapt *Adapter) ExpectReply(channel string, timeout time.Duration, resolve func(event CloudEvent, ok bool)) string {
+	replyTo := uuid.NewString()
+	pending := &pendingReply{channel: channel, resolve: resolve}
+
+	adapt.replies.lock.Lock()
+	adapt.replies.pending[replyTo] = pending
+	pending.timer = time.AfterFunc(RequestTimeout(timeout), func() {
+		if p := adapt.replies.take(replyTo); p != nil {
+			p.resolve(CloudEvent{}, false)
+		}
+	})
+	adapt.replies.lock.Unlock()
+
+	return replyTo
+}
+
+// CancelReply - Stops waiting on replyTo without resolving it, for
+// requesters that went away
+func (adapt *Adapter) CancelReply(replyTo string) {
+	if pending := adapt.replies.take(replyTo); pending != nil {
+		pending.timer.Stop()
+	}
+}
+
+// Reply - Resolves the request waiting on replyTo. Only a responder allowed
+// to subscribe to the request's channel, and so able to have received it, may
+// answer; others get ErrUnauthorized and the request keeps waiting.
+func (adapt *Adapter) Reply(replyTo string, event CloudEvent, claims *Claims) error {
+	adapt.replies.lock.Lock()
+	pending, ok := adapt.replies.pending[replyTo]
+	if !ok {
+		adapt.replies.lock.Unlock()
+		return ErrNoPendingRequest
+	}
+	if !claims.CanSubscribe(pending.channel) {
+		adapt.replies.lock.Unlock()
+		return ErrUnauthorized
+	}
+	delete(adapt.replies.pending, replyTo)
+	adapt.replies.lock.Unlock()
+
+	pending.timer.Stop()
+	pending.resolve(event, true)
+	return nil
+}
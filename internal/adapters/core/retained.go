@@ -0,0 +1,92 @@
+package core
+
+import (
+	"os"
+	"time"
+)
+
+var (
+	// How long a retained event is kept, 0 keeps it until replaced or
+	// cleared, RETAIN_TTL
+	RetainTTL time.Duration
+	// Keep the latest retained event per subject instead of per channel
+	RetainPerSubject = os.Getenv("RETAIN_PER_SUBJECT") == "true"
+)
+
+func init() {
+	if value, ok := os.LookupEnv("RETAIN_TTL"); ok && value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			panic("Invalid RETAIN_TTL: " + value)
+		}
+		RetainTTL = ttl
+	}
+}
+
+type retainedEvent struct {
+	event   CloudEvent
+	expires time.Time
+}
+
+func (r retainedEvent) expired(now time.Time) bool {
+	return !r.expires.IsZero() && now.After(r.expires)
+}
+
+// Retain - Stores event as the channel's last value, delivered to later
+// subscribers. Publishing a retained event without data clears it.
+func (adapt *Adapter) Retain(channel string, event CloudEvent) {
+	key := ""
+	if RetainPerSubject {
+		key = event.Subject
+	}
+
+	s := adapt.shardFor(channel)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if event.Data == "" {
+		delete(s.retained[channel], key)
+		if len(s.retained[channel]) == 0 {
+			delete(s.retained, channel)
+		}
+		return
+	}
+
+	if s.retained[channel] == nil {
+		s.retained[channel] = make(map[string]retainedEvent)
+	}
+	entry := retainedEvent{event: event}
+	if RetainTTL > 0 {
+		entry.expires = time.Now().Add(RetainTTL)
+	}
+	s.retained[channel][key] = entry
+}
+
+// ClearRetained - Drops every retained event on a channel
+func (adapt *Adapter) ClearRetained(channel string) {
+	s := adapt.shardFor(channel)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.retained, channel)
+}
+
+// Retained - Unexpired retained events for a channel
+func (adapt *Adapter) Retained(channel string) []CloudEvent {
+	s := adapt.shardFor(channel)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	var events []CloudEvent
+	for key, entry := range s.retained[channel] {
+		if entry.expired(now) || DiscardExpired(entry.event, "retained") {
+			delete(s.retained[channel], key)
+			continue
+		}
+		events = append(events, entry.event)
+	}
+	if len(s.retained[channel]) == 0 {
+		delete(s.retained, channel)
+	}
+	return events
+}
@@ -0,0 +1,310 @@
+package core
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/josh-tracey/scribe"
+)
+
+// How often the routing rules file is checked for changes,
+// ROUTING_RELOAD_INTERVAL
+var RoutingReloadInterval = 5 * time.Second
+
+func init() {
+	if interval, err := time.ParseDuration(os.Getenv("ROUTING_RELOAD_INTERVAL")); err == nil && interval > 0 {
+		RoutingReloadInterval = interval
+	}
+}
+
+// ExtRoutedFrom - Extension attribute holding the type a republished event
+// was published with
+const ExtRoutedFrom = "routedfrom"
+
+// Events each routing rule matched, keyed by rule name, published on
+// /debug/vars
+var routedEvents = expvar.NewMap("routed_events")
+
+// RuleMatch - Attributes an event must have for a rule to apply. Type is a
+// channel pattern as in token claims, the others are path.Match globs.
+// Empty fields match anything; listed extensions must be present.
+type RuleMatch struct {
+	Type            string            `json:"type,omitempty"`
+	Source          string            `json:"source,omitempty"`
+	Subject         string            `json:"subject,omitempty"`
+	DataContentType string            `json:"datacontenttype,omitempty"`
+	DataSchema      string            `json:"dataschema,omitempty"`
+	Extensions      map[string]string `json:"extensions,omitempty"`
+}
+
+// RuleAction - One step of a rule, exactly one field set. Republish also
+// publishes the event on another channel, Drop stops the original being
+// delivered, Copy sends it to a sink and Tag sets extension attributes seen
+// by later actions and rules.
+type RuleAction struct {
+	Republish string            `json:"republish,omitempty"`
+	Drop      bool              `json:"drop,omitempty"`
+	Copy      string            `json:"copy,omitempty"`
+	Tag       map[string]string `json:"tag,omitempty"`
+}
+
+// Rule - Actions applied to matching events. Final stops later rules being
+// evaluated once this one matches.
+type Rule struct {
+	Name    string       `json:"name"`
+	Match   RuleMatch    `json:"match"`
+	Actions []RuleAction `json:"actions"`
+	Final   bool         `json:"final,omitempty"`
+}
+
+// SinkConfig - Destination outside the agent events are copied to, Type
+// selecting the sink adapter and Target its file path or URL
+type SinkConfig struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Target string `json:"target"`
+}
+
+// RoutingTable - Operator declared sinks and rules, evaluated in order
+type RoutingTable struct {
+	Sinks []SinkConfig `json:"sinks,omitempty"`
+	Rules []Rule       `json:"rules,omitempty"`
+}
+
+// SinkCopy - Event a rule copied to a sink
+type SinkCopy struct {
+	Sink  string
+	Event CloudEvent
+}
+
+// Routed - Outcome of applying the rules to a published event. Events holds
+// the original, unless dropped, followed by republished events.
+type Routed struct {
+	Events []CloudEvent
+	Copies []SinkCopy
+}
+
+// Validate - Checks every rule is named once, has valid patterns and
+// actions, and copies only to declared sinks
+func (t RoutingTable) Validate() error {
+	sinks := make(map[string]bool, len(t.Sinks))
+	for _, sink := range t.Sinks {
+		if sink.Name == "" || sink.Type == "" {
+			return errors.New("sinks need a name and type")
+		}
+		if sinks[sink.Name] {
+			return fmt.Errorf("sink %s declared twice", sink.Name)
+		}
+		sinks[sink.Name] = true
+	}
+
+	names := make(map[string]bool, len(t.Rules))
+	for _, rule := range t.Rules {
+		if rule.Name == "" {
+			return errors.New("rules need a name")
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule %s declared twice", rule.Name)
+		}
+		names[rule.Name] = true
+
+		globs := []string{rule.Match.Source, rule.Match.Subject, rule.Match.DataContentType, rule.Match.DataSchema}
+		for _, glob := range rule.Match.Extensions {
+			globs = append(globs, glob)
+		}
+		for _, glob := range globs {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("rule %s: pattern %q: %w", rule.Name, glob, err)
+			}
+		}
+
+		if len(rule.Actions) == 0 {
+			return fmt.Errorf("rule %s has no actions", rule.Name)
+		}
+		for i, action := range rule.Actions {
+			set := 0
+			for _, ok := range []bool{action.Republish != "", action.Drop, action.Copy != "", len(action.Tag) > 0} {
+				if ok {
+					set++
+				}
+			}
+			if set != 1 {
+				return fmt.Errorf("rule %s action %d must set exactly one of republish, drop, copy or tag", rule.Name, i)
+			}
+			if action.Copy != "" && !sinks[action.Copy] {
+				return fmt.Errorf("rule %s copies to undeclared sink %s", rule.Name, action.Copy)
+			}
+			for name := range action.Tag {
+				if reason := extensionNameViolation(name, ValidationLenient); reason != "" {
+					return fmt.Errorf("rule %s tag %q: %s", rule.Name, name, reason)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Matches - Whether the event has every attribute the match names
+func (m RuleMatch) Matches(event CloudEvent) bool {
+	if m.Type != "" && !MatchChannel(m.Type, event.Type) {
+		return false
+	}
+	for _, attribute := range [...][2]string{
+		{m.Source, event.Source},
+		{m.Subject, event.Subject},
+		{m.DataContentType, event.DataContentType},
+		{m.DataSchema, event.DataSchema},
+	} {
+		if attribute[0] == "" {
+			continue
+		}
+		if ok, _ := path.Match(attribute[0], attribute[1]); !ok {
+			return false
+		}
+	}
+	for name, glob := range m.Extensions {
+		value, ok := event.Extensions[name]
+		if !ok {
+			return false
+		}
+		if ok, _ := path.Match(glob, value); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Router - Applies the routing table to published events, swapping in a new
+// table when the rules file changes. Sink copies are handed to copies.
+type Router struct {
+	table  RoutingTable
+	copies chan<- *SinkCopy
+	lock   sync.RWMutex
+}
+
+func NewRouter(table RoutingTable, copies chan<- *SinkCopy) (*Router, error) {
+	if err := table.Validate(); err != nil {
+		return nil, err
+	}
+	return &Router{table: table, copies: copies}, nil
+}
+
+// Reload - Replaces the routing table, keeping the current one when the new
+// one is invalid
+func (r *Router) Reload(table RoutingTable) error {
+	if err := table.Validate(); err != nil {
+		return err
+	}
+	r.lock.Lock()
+	r.table = table
+	r.lock.Unlock()
+	return nil
+}
+
+// Apply - Runs the rules over an event in order. Republished events are not
+// evaluated again, so rules cannot loop, and get a fresh ID so receivers do
+// not take them for duplicates of the original.
+func (r *Router) Apply(event CloudEvent) Routed {
+	r.lock.RLock()
+	rules := r.table.Rules
+	r.lock.RUnlock()
+
+	if len(rules) == 0 {
+		return Routed{Events: []CloudEvent{event}}
+	}
+
+	current, dropped := event, false
+	var republished []CloudEvent
+	var copies []SinkCopy
+	for _, rule := range rules {
+		if !rule.Match.Matches(current) {
+			continue
+		}
+		routedEvents.Add(rule.Name, 1)
+
+		for _, action := range rule.Actions {
+			switch {
+			case len(action.Tag) > 0:
+				current = withExtensions(current, action.Tag)
+			case action.Republish != "":
+				routed := withExtensions(current, map[string]string{ExtRoutedFrom: event.Type})
+				routed.ID = uuid.NewString()
+				routed.Type = action.Republish
+				republished = append(republished, routed)
+			case action.Copy != "":
+				copies = append(copies, SinkCopy{Sink: action.Copy, Event: current})
+			case action.Drop:
+				dropped = true
+			}
+		}
+		if rule.Final {
+			break
+		}
+	}
+
+	var events []CloudEvent
+	if !dropped {
+		events = append(events, current)
+	}
+	return Routed{Events: append(events, republished...), Copies: copies}
+}
+
+// Route - Applies the rules, queueing sink copies, and returns the events to
+// deliver
+func (r *Router) Route(event CloudEvent) []CloudEvent {
+	routed := r.Apply(event)
+	for i := range routed.Copies {
+		r.copies <- &routed.Copies[i]
+	}
+	return routed.Events
+}
+
+// Watch - Reloads the routing table whenever modified reports a new time,
+// calling reloaded with the sinks of each table taken into use
+func (r *Router) Watch(load func() (RoutingTable, error), modified func() (time.Time, error), reloaded func(sinks []SinkConfig), logger *scribe.Logger) {
+	last, _ := modified()
+	ticker := time.NewTicker(RoutingReloadInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		at, err := modified()
+		if err != nil {
+			logger.Error("core::Router.Watch => %s", err)
+			continue
+		}
+		if at.Equal(last) {
+			continue
+		}
+		last = at
+
+		table, err := load()
+		if err == nil {
+			err = r.Reload(table)
+		}
+		if err != nil {
+			logger.Error("core::Router.Watch => keeping previous routing rules: %s", err)
+			continue
+		}
+		reloaded(table.Sinks)
+		logger.Info("Routing rules reloaded: %d rules, %d sinks", len(table.Rules), len(table.Sinks))
+	}
+}
+
+// withExtensions - Copy of the event with the extensions set, leaving the
+// original's map untouched
+func withExtensions(event CloudEvent, set map[string]string) CloudEvent {
+	extensions := make(map[string]string, len(event.Extensions)+len(set))
+	for name, value := range event.Extensions {
+		extensions[name] = value
+	}
+	for name, value := range set {
+		extensions[name] = value
+	}
+	event.Extensions = extensions
+	return event
+}
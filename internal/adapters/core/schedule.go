@@ -0,0 +1,241 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/josh-tracey/scribe"
+)
+
+var (
+	ErrNotScheduled = errors.New("no scheduled event with that id")
+
+	// Resolution of the delivery scheduler, SCHEDULER_TICK
+	SchedulerTick = 100 * time.Millisecond
+	// Slots in the scheduler's timer wheel
+	SchedulerSlots = 512
+)
+
+func init() {
+	if tick, err := time.ParseDuration(os.Getenv("SCHEDULER_TICK")); err == nil && tick > 0 {
+		SchedulerTick = tick
+	}
+}
+
+const (
+	// ExtDeliverAt - Extension attribute holding the RFC 3339 time an event is
+	// due
+	ExtDeliverAt = "deliverat"
+	// ExtDelay - Extension attribute holding how long to hold an event, as a
+	// Go duration such as "90s"
+	ExtDelay = "delay"
+
+	AckScheduled = "scheduled"
+	AckCancelled = "cancelled"
+)
+
+// DeliverAt - When an event asks to be delivered. ok is false for events to
+// deliver immediately.
+func DeliverAt(event CloudEvent, now time.Time) (at time.Time, ok bool, err error) {
+	if value := event.Extensions[ExtDeliverAt]; value != "" {
+		at, err = time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return at, false, fmt.Errorf("invalid %s: %w", ExtDeliverAt, err)
+		}
+		return at, at.After(now), nil
+	}
+	if value := event.Extensions[ExtDelay]; value != "" {
+		delay, err := time.ParseDuration(value)
+		if err != nil {
+			return at, false, fmt.Errorf("invalid %s: %w", ExtDelay, err)
+		}
+		return now.Add(delay), delay > 0, nil
+	}
+	return at, false, nil
+}
+
+// pinDelivery - Copy of the event with its delay extension replaced by the
+// time it resolved to
+func pinDelivery(event CloudEvent, at time.Time) CloudEvent {
+	if _, ok := event.Extensions[ExtDelay]; !ok {
+		return event
+	}
+	event = withExtensions(event, map[string]string{ExtDeliverAt: at.UTC().Format(time.RFC3339Nano)})
+	delete(event.Extensions, ExtDelay)
+	return event
+}
+
+// ScheduledEvent - Event held until DeliverAt, then published to Channel on
+// behalf of Subject
+type ScheduledEvent struct {
+	Subject   string     `json:"subject"`
+	Channel   string     `json:"channel"`
+	Retain    bool       `json:"retain,omitempty"`
+	DeliverAt time.Time  `json:"deliverAt"`
+	Event     CloudEvent `json:"event"`
+}
+
+func (s ScheduledEvent) key() string {
+	return s.Subject + "\x00" + s.Event.ID
+}
+
+type scheduled struct {
+	ScheduledEvent
+	rounds    int
+	cancelled bool
+}
+
+// Scheduler - Hashed timer wheel holding events until they are due. Each
+// tick visits one slot; entries further out than one turn of the wheel count
+// down their remaining rounds. Pending events are written through save by
+// Run when they change.
+type Scheduler struct {
+	slots   [][]*scheduled
+	pos     int
+	pending map[string]*scheduled
+	dirty   bool
+	save    func([]ScheduledEvent) error
+	lock    sync.Mutex
+}
+
+func NewScheduler(events []ScheduledEvent, save func([]ScheduledEvent) error) *Scheduler {
+	s := &Scheduler{
+		slots:   make([][]*scheduled, SchedulerSlots),
+		pending: make(map[string]*scheduled),
+		save:    save,
+	}
+	for _, event := range events {
+		s.insert(event)
+	}
+	return s
+}
+
+// Schedule - Holds an event until it is due, replacing an event the subject
+// scheduled earlier with the same ID. A delay is replaced by the absolute
+// deliverat it resolved to, so receivers that schedule the event again once
+// it is published see it as already due.
+func (s *Scheduler) Schedule(event ScheduledEvent) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.insert(event)
+	s.dirty = true
+}
+
+// Replace - Schedules an event in place of one the subject scheduled earlier
+// with the same ID that is still pending. False, leaving the scheduler
+// unchanged, when there is none.
+func (s *Scheduler) Replace(event ScheduledEvent) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.pending[event.key()]; !ok {
+		return false
+	}
+	s.insert(event)
+	s.dirty = true
+	return true
+}
+
+// insert - Places an event in its slot, callers must hold lock
+func (s *Scheduler) insert(event ScheduledEvent) {
+	event.Event = pinDelivery(event.Event, event.DeliverAt)
+	if previous, ok := s.pending[event.key()]; ok {
+		previous.cancelled = true
+	}
+
+	ticks := int((time.Until(event.DeliverAt) + SchedulerTick - 1) / SchedulerTick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	entry := &scheduled{ScheduledEvent: event, rounds: (ticks - 1) / len(s.slots)}
+	slot := (s.pos + ticks) % len(s.slots)
+	s.slots[slot] = append(s.slots[slot], entry)
+	s.pending[event.key()] = entry
+}
+
+// Cancel - Drops an event the subject scheduled
+func (s *Scheduler) Cancel(subject string, id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := ScheduledEvent{Subject: subject, Event: CloudEvent{ID: id}}.key()
+	entry, ok := s.pending[key]
+	if !ok {
+		return ErrNotScheduled
+	}
+	entry.cancelled = true
+	delete(s.pending, key)
+	s.dirty = true
+	return nil
+}
+
+// advance - Moves the wheel on one slot and returns the events now due
+func (s *Scheduler) advance() []ScheduledEvent {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pos = (s.pos + 1) % len(s.slots)
+	var due []ScheduledEvent
+	var waiting []*scheduled
+	for _, entry := range s.slots[s.pos] {
+		switch {
+		case entry.cancelled:
+		case entry.rounds > 0:
+			entry.rounds--
+			waiting = append(waiting, entry)
+		default:
+			due = append(due, entry.ScheduledEvent)
+			delete(s.pending, entry.key())
+			s.dirty = true
+		}
+	}
+	s.slots[s.pos] = waiting
+	return due
+}
+
+// flush - Saves pending events if they changed since the last save
+func (s *Scheduler) flush() error {
+	s.lock.Lock()
+	if !s.dirty {
+		s.lock.Unlock()
+		return nil
+	}
+	events := make([]ScheduledEvent, 0, len(s.pending))
+	for _, entry := range s.pending {
+		events = append(events, entry.ScheduledEvent)
+	}
+	s.dirty = false
+	s.lock.Unlock()
+
+	if err := s.save(events); err != nil {
+		s.lock.Lock()
+		s.dirty = true
+		s.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Run - Turns the wheel every SchedulerTick, passing due events to deliver,
+// and saves changes every DurableFlushInterval
+func (s *Scheduler) Run(deliver func(ScheduledEvent), logger *scribe.Logger) {
+	ticker := time.NewTicker(SchedulerTick)
+	defer ticker.Stop()
+	flush := time.NewTicker(DurableFlushInterval)
+	defer flush.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, event := range s.advance() {
+				deliver(event)
+			}
+		case <-flush.C:
+			if err := s.flush(); err != nil {
+				logger.Error("core::Scheduler.Run => %s", err)
+			}
+		}
+	}
+}
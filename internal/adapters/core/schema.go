@@ -0,0 +1,255 @@
+package core
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"mime"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SchemaAction - What happens to an event whose data breaks its schema
+type SchemaAction string
+
+const (
+	// SchemaReject - The publish is refused with a schema_violation error
+	SchemaReject SchemaAction = "reject"
+	// SchemaQuarantine - The event is moved to QuarantinePrefix plus its
+	// type, with the violations in its schemaerror extension
+	SchemaQuarantine SchemaAction = "quarantine"
+
+	// ExtSchemaError - Extension attribute recording why a quarantined
+	// event was moved
+	ExtSchemaError = "schemaerror"
+)
+
+const ErrCodeSchemaViolation = "schema_violation"
+
+var (
+	// Action taken on non-conforming events, SCHEMA_VIOLATION
+	SchemaViolation = SchemaReject
+	// Prefix of the channels quarantined events are moved to,
+	// SCHEMA_QUARANTINE_PREFIX
+	QuarantinePrefix = "quarantine."
+)
+
+func init() {
+	if value, ok := os.LookupEnv("SCHEMA_VIOLATION"); ok {
+		switch action := SchemaAction(strings.ToLower(value)); action {
+		case SchemaReject, SchemaQuarantine:
+			SchemaViolation = action
+		default:
+			panic("Invalid SCHEMA_VIOLATION: " + value)
+		}
+	}
+	if prefix := os.Getenv("SCHEMA_QUARANTINE_PREFIX"); prefix != "" {
+		QuarantinePrefix = prefix
+	}
+}
+
+// Events failing their schema, keyed by rejected or quarantined, published
+// on /debug/vars
+var schemaViolations = expvar.NewMap("schema_violations")
+
+// SchemaDocument - JSON Schema registered for an event type. ID is the
+// schema's $id, matched against an event's dataschema. The default schema
+// checks events of the type that name no dataschema.
+type SchemaDocument struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Default bool            `json:"default,omitempty"`
+	File    string          `json:"file,omitempty"`
+	Schema  json.RawMessage `json:"schema,omitempty"`
+}
+
+// SchemasMessage - Outgoing list of registered schemas
+type SchemasMessage struct {
+	Type    string           `json:"type"`
+	Schemas []SchemaDocument `json:"schemas"`
+}
+
+// SchemaError - Why an event's data does not conform to its schema
+type SchemaError struct {
+	Schema     string
+	Violations []Violation
+}
+
+func (e *SchemaError) Error() string {
+	reasons := make([]string, 0, len(e.Violations))
+	for i, v := range e.Violations {
+		if i == 10 {
+			reasons = append(reasons, fmt.Sprintf("and %d more", len(e.Violations)-i))
+			break
+		}
+		reasons = append(reasons, v.Attribute+" "+v.Reason)
+	}
+	return "data does not match schema " + e.Schema + ": " + strings.Join(reasons, "; ")
+}
+
+type registeredSchema struct {
+	document SchemaDocument
+	schema   *jsonSchema
+}
+
+// SchemaRegistry - JSON Schemas event data is validated against, by type
+// and dataschema. Read only once built, so safe for concurrent use.
+type SchemaRegistry struct {
+	defaults map[string]*registeredSchema
+	byID     map[string]*registeredSchema
+	types    map[string]bool
+	all      []*registeredSchema
+}
+
+// NewSchemaRegistry - Compiles the documents, failing on invalid schemas, a
+// second default for a type, a repeated $id or a schema that can never be
+// selected
+func NewSchemaRegistry(documents []SchemaDocument) (*SchemaRegistry, error) {
+	r := &SchemaRegistry{
+		defaults: make(map[string]*registeredSchema),
+		byID:     make(map[string]*registeredSchema),
+		types:    make(map[string]bool),
+	}
+	for _, document := range documents {
+		schema, err := compileSchema(document.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("schema %s: %w", document.File, err)
+		}
+		registered := &registeredSchema{document: document, schema: schema}
+
+		if !document.Default && document.ID == "" {
+			return nil, fmt.Errorf("schema %s: needs an $id or to be the default for type %s", document.File, document.Type)
+		}
+		if document.Default {
+			if _, ok := r.defaults[document.Type]; ok {
+				return nil, fmt.Errorf("schema %s: type %s already has a default schema", document.File, document.Type)
+			}
+			r.defaults[document.Type] = registered
+		}
+		if document.ID != "" {
+			if _, ok := r.byID[document.ID]; ok {
+				return nil, fmt.Errorf("schema %s: $id %s is already registered", document.File, document.ID)
+			}
+			r.byID[document.ID] = registered
+		}
+		r.types[document.Type] = true
+		r.all = append(r.all, registered)
+	}
+
+	sort.Slice(r.all, func(i, j int) bool {
+		if r.all[i].document.Type != r.all[j].document.Type {
+			return r.all[i].document.Type < r.all[j].document.Type
+		}
+		return r.all[i].document.ID < r.all[j].document.ID
+	})
+	return r, nil
+}
+
+// Check - Validates an event's data against the schema named by its
+// dataschema, or its type's default schema. Types without schemas pass.
+func (r *SchemaRegistry) Check(event CloudEvent) error {
+	if !r.types[event.Type] {
+		return nil
+	}
+
+	registered := r.defaults[event.Type]
+	name := event.Type
+	if event.DataSchema != "" {
+		registered, name = r.byID[event.DataSchema], event.DataSchema
+		if registered == nil || registered.document.Type != event.Type {
+			return &SchemaError{Schema: name, Violations: []Violation{{
+				Attribute: "dataschema",
+				Reason:    "is not registered for type " + event.Type,
+			}}}
+		}
+	}
+	if registered == nil {
+		return &SchemaError{Schema: name, Violations: []Violation{{
+			Attribute: "dataschema",
+			Reason:    "is required for type " + event.Type,
+		}}}
+	}
+
+	if !isJSONContentType(event.DataContentType) {
+		return &SchemaError{Schema: name, Violations: []Violation{{
+			Attribute: "datacontenttype",
+			Reason:    fmt.Sprintf("must be JSON, got %q", event.DataContentType),
+		}}}
+	}
+	var data interface{}
+	if err := json.Unmarshal([]byte(event.Data), &data); err != nil {
+		return &SchemaError{Schema: name, Violations: []Violation{{Attribute: "data", Reason: "is not valid JSON"}}}
+	}
+
+	var violations []Violation
+	registered.schema.validate(data, "", &violations)
+	if len(violations) > 0 {
+		return &SchemaError{Schema: name, Violations: violations}
+	}
+	return nil
+}
+
+// Admit - Applies Check and SchemaViolation to an incoming event, returning
+// the event to publish, moved to its quarantine channel when quarantining is
+// allowed, or the error to reject it with
+func (r *SchemaRegistry) Admit(event CloudEvent, quarantine bool) (CloudEvent, error) {
+	err := r.Check(event)
+	if err == nil {
+		return event, nil
+	}
+	if !quarantine || SchemaViolation != SchemaQuarantine {
+		schemaViolations.Add("rejected", 1)
+		return event, err
+	}
+	schemaViolations.Add("quarantined", 1)
+	return Quarantine(event, err), nil
+}
+
+// Quarantine - Copy of the event moved to its quarantine channel with the
+// reason attached
+func Quarantine(event CloudEvent, reason error) CloudEvent {
+	extensions := make(map[string]string, len(event.Extensions)+1)
+	for name, value := range event.Extensions {
+		extensions[name] = value
+	}
+	extensions[ExtSchemaError] = reason.Error()
+	event.Extensions = extensions
+	event.Type = QuarantinePrefix + event.Type
+	return event
+}
+
+// List - Registered schemas without their documents, by type and $id
+func (r *SchemaRegistry) List() []SchemaDocument {
+	documents := make([]SchemaDocument, len(r.all))
+	for i, registered := range r.all {
+		documents[i] = registered.document
+		documents[i].Schema = nil
+	}
+	return documents
+}
+
+// Get - Schema registered for a type under the given $id, or the type's
+// default schema when id is empty
+func (r *SchemaRegistry) Get(eventType string, id string) (SchemaDocument, bool) {
+	registered := r.defaults[eventType]
+	if id != "" {
+		registered = r.byID[id]
+	}
+	if registered == nil || registered.document.Type != eventType {
+		return SchemaDocument{}, false
+	}
+	return registered.document, true
+}
+
+// isJSONContentType - Missing, application/json or a +json media type
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
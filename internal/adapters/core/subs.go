@@ -67,6 +67,18 @@ func (s *sub) RemoveClientId(client string) {
 	}
 }
 
+// RemoveRef - Thread Safe method of removing a single subscription from Sub
+func (s *sub) RemoveRef(refID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, clientId := range s.clients {
+		if *clientId[0] == refID {
+			s.clients = removeArr(s.clients, i)
+			return
+		}
+	}
+}
+
 func removeArr(s [][]*string, i int) [][]*string {
 	s[i] = s[len(s)-1]
 	return s[:len(s)-1]
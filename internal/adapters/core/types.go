@@ -22,32 +22,110 @@ func (c *CoreClient) AddRefID(refID string) {
 
 // PublishEvent - Publish incoming message type
 type PublishEvent struct {
-	Type    string     `json:"type"`
-	Token   string     `json:"token"`
-	Channel string     `json:"channel"`
+	Type    string `json:"type"`
+	ID      string `json:"id,omitempty"`
+	Token   string `json:"token"`
+	Channel string `json:"channel"`
+	Retain  bool   `json:"retain,omitempty"`
+	// Milliseconds a request waits for its reply
+	Timeout int        `json:"timeout,omitempty"`
 	Event   CloudEvent `json:"event"`
 }
 
 // SubscribeMessage - Subscribe incoming message type
 type SubscribeMessage struct {
 	Type     string   `json:"type"`
+	ID       string   `json:"id,omitempty"`
 	Token    string   `json:"token"`
 	Channels []string `json:"channels"`
+	// Consumer group sharing the channels' events
+	Group string `json:"group,omitempty"`
+	// Durable subscription name, resumed from its cursors
+	Durable string `json:"durable,omitempty"`
+	// Metadata announced to the other members of presence channels
+	Meta map[string]interface{} `json:"meta,omitempty"`
+}
+
+// AckMessage - Outgoing acknowledgement of a client command, keyed by the
+// command's correlation ID. Refs maps each subscribed channel to its ref ID.
+// Duplicate marks a publish dropped as already published.
+type AckMessage struct {
+	Type      string            `json:"type"`
+	ID        string            `json:"id,omitempty"`
+	Channel   string            `json:"channel,omitempty"`
+	Channels  []string          `json:"channels,omitempty"`
+	Refs      map[string]string `json:"refs,omitempty"`
+	Duplicate bool              `json:"duplicate,omitempty"`
+}
+
+// MissedMessage - Tells a slow client how many events were dropped for it
+type MissedMessage struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// ErrorMessage - Outgoing rejection of a client command
+type ErrorMessage struct {
+	Type    string `json:"type"`
+	ID      string `json:"id,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	AckPublished    = "published"
+	AckSubscribed   = "subscribed"
+	AckUnsubscribed = "unsubscribed"
+	AckRefreshed    = "refreshed"
+	AckCleared      = "cleared"
+	AckDelivered    = "delivered"
+
+	ErrCodeInvalidRequest = "invalid_request"
+	ErrCodeInvalidToken   = "invalid_token"
+	ErrCodeUnauthorized   = "unauthorized"
+	ErrCodeRateLimited    = "rate_limited"
+	ErrCodeNotConnected   = "not_connected"
+	ErrCodeCursorExpired  = "cursor_expired"
+)
+
+func NewErrorMessage(id string, code string, message string) ErrorMessage {
+	return ErrorMessage{
+		Type:    "error",
+		ID:      id,
+		Code:    code,
+		Message: message,
+	}
 }
 
 func (p PublishEvent) isMessage() {}
 
 func (p SubscribeMessage) isMessage() {}
 
+func (a AckMessage) isMessage() {}
+
+func (e ErrorMessage) isMessage() {}
+
+func (m MissedMessage) isMessage() {}
+
 type PeerRequest struct {
 	PeerAddr  string
 	Channel   string
+	Group     string
 	Ephemeral bool
 }
 
+// PeerEvent - Event bound for a peer server. Channel and Group are set when
+// the peer was picked from a consumer group, Attempts counting the failed
+// publishes so far. Direct messages carry their inbox channel and sender in
+// Channel, From and Connection.
 type PeerEvent struct {
 	PeerServer string
 	Event      CloudEvent
+	Channel    string
+	Group      string
+	Attempts   int
+	From       string
+	Connection string
 }
 
 // CloudEvent - https://github.com/cloudevents/spec/blob/v1.0.1/spec.md
@@ -58,9 +136,12 @@ type CloudEvent struct {
 	Subject         string `json:"subject"`
 	Data            string `json:"data"`
 	DataContentType string `json:"datacontenttype"`
+	DataSchema      string `json:"dataschema,omitempty"`
 	Time            string `json:"time"`
 	SpecVersion     string `json:"specversion"`
 	Meta            string `json:"meta"`
+	// Extension context attributes such as replyto
+	Extensions map[string]string `json:"extensions,omitempty"`
 }
 
 type SubscribeRequest[T any] struct {
@@ -73,6 +154,11 @@ type PublishRequest[T any] struct {
 	Client T
 }
 
+type DirectRequest[T any] struct {
+	DirectEvent
+	Client T
+}
+
 func ConvertToStringSlice(input []interface{}) []string {
 	s := make([]string, len(input))
 	for i, v := range input {
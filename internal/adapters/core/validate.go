@@ -0,0 +1,170 @@
+package core
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ValidationMode - How strictly published events are held to the
+// CloudEvents 1.0 specification
+type ValidationMode string
+
+const (
+	// ValidationStrict - Every violation is rejected
+	ValidationStrict ValidationMode = "strict"
+	// ValidationLenient - A missing id is generated and a missing
+	// specversion defaults to 1.0, other violations are rejected
+	ValidationLenient ValidationMode = "lenient"
+
+	// SpecVersion - CloudEvents specification version events conform to
+	SpecVersion = "1.0"
+)
+
+const ErrCodeInvalidEvent = "invalid_event"
+
+// Validation applied to published events, CLOUDEVENTS_VALIDATION
+var Validation = ValidationLenient
+
+func init() {
+	if value, ok := os.LookupEnv("CLOUDEVENTS_VALIDATION"); ok {
+		switch mode := ValidationMode(strings.ToLower(value)); mode {
+		case ValidationStrict, ValidationLenient:
+			Validation = mode
+		default:
+			panic("Invalid CLOUDEVENTS_VALIDATION: " + value)
+		}
+	}
+}
+
+// Context attribute names extensions may not reuse
+var contextAttributes = map[string]bool{
+	"id":              true,
+	"source":          true,
+	"specversion":     true,
+	"type":            true,
+	"datacontenttype": true,
+	"dataschema":      true,
+	"subject":         true,
+	"time":            true,
+	"data":            true,
+}
+
+// Violation - One attribute breaking the specification
+type Violation struct {
+	Attribute string
+	Reason    string
+}
+
+// ValidationError - Every violation found in a rejected event
+type ValidationError []Violation
+
+func (e ValidationError) Error() string {
+	reasons := make([]string, len(e))
+	for i, v := range e {
+		reasons[i] = v.Attribute + " " + v.Reason
+	}
+	return "invalid cloudevent: " + strings.Join(reasons, "; ")
+}
+
+// ValidateEvent - Checks an event against the CloudEvents 1.0 attribute
+// rules, returning it with lenient defaults filled in or a ValidationError
+// listing every violation
+func ValidateEvent(event CloudEvent, mode ValidationMode) (CloudEvent, error) {
+	if mode == ValidationLenient {
+		if event.ID == "" {
+			event.ID = uuid.NewString()
+		}
+		if event.SpecVersion == "" {
+			event.SpecVersion = SpecVersion
+		}
+	}
+
+	var violations ValidationError
+	violate := func(attribute string, reason string) {
+		violations = append(violations, Violation{Attribute: attribute, Reason: reason})
+	}
+
+	if event.ID == "" {
+		violate("id", "is required")
+	}
+	if event.Type == "" {
+		violate("type", "is required")
+	}
+	switch {
+	case event.SpecVersion == "":
+		violate("specversion", "is required")
+	case event.SpecVersion != SpecVersion:
+		violate("specversion", fmt.Sprintf("must be %s, got %q", SpecVersion, event.SpecVersion))
+	}
+	switch {
+	case event.Source == "":
+		violate("source", "is required")
+	case !isURIReference(event.Source):
+		violate("source", fmt.Sprintf("must be a URI-reference, got %q", event.Source))
+	}
+	if event.Time != "" {
+		if _, err := time.Parse(time.RFC3339, event.Time); err != nil {
+			violate("time", fmt.Sprintf("must be an RFC 3339 timestamp, got %q", event.Time))
+		}
+	}
+	if event.DataSchema != "" {
+		if u, err := url.Parse(event.DataSchema); err != nil || !u.IsAbs() || !isURIReference(event.DataSchema) {
+			violate("dataschema", fmt.Sprintf("must be an absolute URI, got %q", event.DataSchema))
+		}
+	}
+	if event.DataContentType != "" {
+		if _, _, err := mime.ParseMediaType(event.DataContentType); err != nil {
+			violate("datacontenttype", fmt.Sprintf("must be a media type, got %q", event.DataContentType))
+		}
+	}
+	names := make([]string, 0, len(event.Extensions))
+	for name := range event.Extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if reason := extensionNameViolation(name, mode); reason != "" {
+			violate(fmt.Sprintf("extension %q", name), reason)
+		}
+	}
+
+	if len(violations) > 0 {
+		return event, violations
+	}
+	return event, nil
+}
+
+// isURIReference - RFC 3986 URI-reference, absolute or relative
+func isURIReference(value string) bool {
+	if strings.ContainsAny(value, " \t\r\n") {
+		return false
+	}
+	_, err := url.Parse(value)
+	return err == nil
+}
+
+func extensionNameViolation(name string, mode ValidationMode) string {
+	if name == "" {
+		return "name is empty"
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return "name must only contain lowercase letters and digits"
+		}
+	}
+	if contextAttributes[name] {
+		return "name is a context attribute"
+	}
+	// The specification only recommends the limit
+	if mode == ValidationStrict && len(name) > 20 {
+		return "name must not exceed 20 characters"
+	}
+	return ""
+}
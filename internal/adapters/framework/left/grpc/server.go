@@ -4,25 +4,30 @@ import (
 	"context"
 	"errors"
 	"net"
-	"os"
+	"strconv"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
 	"github.com/josh-tracey/eventual-agent/internal/pb"
 	"github.com/josh-tracey/eventual-agent/internal/ports"
-	"github.com/josh-tracey/notary"
 	"github.com/josh-tracey/scribe"
 )
 
-var jwtTokenSecret = os.Getenv("JWT_TOKEN_SECRET")
-
 type Adapter struct {
 	pb.UnimplementedClientServiceServer
 	core           *core.Adapter
 	logger         *scribe.Logger
 	publishChannel chan *core.PeerEvent
 	subsChannel    chan *core.PeerRequest
+	inboxChannel   chan *core.PeerEvent
+	verifier       ports.TokenVerifier
+	history        ports.MessageQueuePort
+	scheduler      *core.Scheduler
+	schemas        *core.SchemaRegistry
 }
 
 func New(
@@ -30,6 +35,11 @@ func New(
 	logger *scribe.Logger,
 	publishChannel chan *core.PeerEvent,
 	subsChannel chan *core.PeerRequest,
+	inboxChannel chan *core.PeerEvent,
+	verifier ports.TokenVerifier,
+	history ports.MessageQueuePort,
+	scheduler *core.Scheduler,
+	schemas *core.SchemaRegistry,
 ) *Adapter {
 
 	value, ok := c.(*core.Adapter)
@@ -41,16 +51,25 @@ func New(
 		core:           value,
 		publishChannel: publishChannel,
 		subsChannel:    subsChannel,
+		inboxChannel:   inboxChannel,
+		verifier:       verifier,
+		history:        history,
+		scheduler:      scheduler,
+		schemas:        schemas,
 	}
 }
 
 func (a *Adapter) Subscribe(ctx context.Context, req *pb.EventSubRequest) (*pb.EventSubResponse, error) {
-	valid, err := notary.New(jwtTokenSecret).VerifyToken(req.Token)
+	claims, err := a.verifier.Verify(req.Token)
 	if err != nil {
-		return nil, err
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if !claims.CanSubscribe(req.Channel) {
+		a.logger.Warn("grpc::Adapter.Subscribe => %s denied subscribe to '%s'", claims.Subject, req.Channel)
+		return nil, status.Errorf(codes.PermissionDenied, "not authorized to subscribe to %s", req.Channel)
 	}
-	if !valid {
-		return nil, errors.New("Invalid token")
+	if !a.core.Limits().AllowSubscribe("", claims.Subject, req.Channel) {
+		return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
 	}
 
 	a.logger.Debug("PeerServer: %s", req.PeerServer)
@@ -58,43 +77,268 @@ func (a *Adapter) Subscribe(ctx context.Context, req *pb.EventSubRequest) (*pb.E
 	a.subsChannel <- &core.PeerRequest{
 		PeerAddr:  req.PeerServer,
 		Channel:   req.Channel,
+		Group:     req.Group,
 		Ephemeral: false,
 	}
 
-	a.core.AddPeer(req.PeerServer, req.Channel, false)
+	a.core.AddGroupPeer(req.PeerServer, req.Channel, req.Group, false)
+	if user, ok := core.InboxUser(req.Channel); ok {
+		a.core.AddInboxPeer(user, req.PeerServer)
+	}
+
+	if retained := a.core.Retained(req.Channel); len(retained) > 0 {
+		go func() {
+			for _, event := range retained {
+				a.publishChannel <- &core.PeerEvent{
+					PeerServer: req.PeerServer,
+					Event:      event,
+				}
+			}
+		}()
+	}
 
 	return &pb.EventSubResponse{SubscriptionId: ""}, nil
 
 }
 
 func (a *Adapter) Publish(ctx context.Context, req *pb.EventPubRequest) (*pb.EventPubResponse, error) {
-	valid, err := notary.New(jwtTokenSecret).VerifyToken(req.Token)
+	claims, err := a.verifier.Verify(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if req.From != "" {
+		return a.forward(claims, req)
+	}
+	if !claims.CanPublish(req.Data.GetType()) {
+		a.logger.Warn("grpc::Adapter.Publish => %s denied publish to '%s'", claims.Subject, req.Data.GetType())
+		return nil, status.Errorf(codes.PermissionDenied, "not authorized to publish to %s", req.Data.GetType())
+	}
+	if !a.core.Limits().AllowPublish("", claims.Subject, req.Data.GetType()) {
+		return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+
+	event, err := core.ValidateEvent(pb.ToCore(req.Data), core.Validation)
 	if err != nil {
-		return nil, err
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if event, err = a.schemas.Admit(event, true); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	stamped, err := core.StampExpiry(event, time.Now())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	at, scheduled, err := core.DeliverAt(event, time.Now())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	pending := core.ScheduledEvent{
+		Subject:   claims.Subject,
+		Channel:   req.Channel,
+		Retain:    req.Retain,
+		DeliverAt: at,
+		Event:     event,
+	}
+	// Rescheduling a pending event replaces it rather than being taken for a
+	// duplicate of it
+	if scheduled && a.scheduler.Replace(pending) {
+		return &pb.EventPubResponse{SubscriptionId: req.SubscriptionId}, nil
+	}
+	if a.core.Duplicate(event, "grpc") {
+		return &pb.EventPubResponse{SubscriptionId: req.SubscriptionId, Duplicate: true}, nil
 	}
-	if !valid {
-		return nil, errors.New("Invalid token")
+	if scheduled {
+		a.scheduler.Schedule(pending)
+		return &pb.EventPubResponse{SubscriptionId: req.SubscriptionId}, nil
 	}
 
-	event := core.CloudEvent{
-		ID:      req.Data.Id,
-		Source:  req.Data.Source,
-		Type:    req.Data.Type,
-		Subject: req.Data.Subject,
-		Data:    req.Data.GetTextData(),
-		Time:    req.Data.Time,
+	event = stamped
+	if core.DiscardExpired(event, "publish") {
+		return &pb.EventPubResponse{SubscriptionId: req.SubscriptionId}, nil
 	}
 
+	// Peer servers forward events another agent has already routed
+	events := []core.CloudEvent{event}
+	if !claims.Peer {
+		events = a.core.Route(event)
+	}
+	for _, event := range events {
+		if req.Retain {
+			a.core.Retain(event.Type, event)
+		}
+		a.history.Enqueue(event.Type, event)
+
+		// Queued before replying so a client awaiting each publish keeps its order
+		for _, peerEvent := range a.core.PeerEvents(event) {
+			a.publishChannel <- peerEvent
+		}
+	}
+
+	return &pb.EventPubResponse{SubscriptionId: req.SubscriptionId}, nil
+}
+
+// forward - Hands a direct message forwarded by another agent to the
+// recipient's WebSocket connections. It is never routed, retained, stored or
+// sent on to peer servers, which would leak it to channel subscribers.
+func (a *Adapter) forward(claims *core.Claims, req *pb.EventPubRequest) (*pb.EventPubResponse, error) {
+	if !claims.Peer {
+		a.logger.Warn("grpc::Adapter.Publish => %s denied forwarding a direct message to '%s'", claims.Subject, req.Channel)
+		return nil, status.Error(codes.PermissionDenied, "only peers forward direct messages")
+	}
+	if _, ok := core.InboxUser(req.Channel); !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "direct messages are forwarded to %s<user>, not '%s'", core.InboxPrefix, req.Channel)
+	}
+
+	event, err := core.ValidateEvent(pb.ToCore(req.Data), core.Validation)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	a.inboxChannel <- &core.PeerEvent{
+		Channel:    req.Channel,
+		Event:      event,
+		From:       req.From,
+		Connection: req.Connection,
+	}
+	return &pb.EventPubResponse{SubscriptionId: req.SubscriptionId}, nil
+}
+
+// Request - Sends a request to peer servers subscribed to its type and waits
+// for the first reply
+func (a *Adapter) Request(ctx context.Context, req *pb.EventRequestRequest) (*pb.EventRequestResponse, error) {
+	claims, err := a.verifier.Verify(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if !claims.CanPublish(req.Data.GetType()) {
+		a.logger.Warn("grpc::Adapter.Request => %s denied publish to '%s'", claims.Subject, req.Data.GetType())
+		return nil, status.Errorf(codes.PermissionDenied, "not authorized to publish to %s", req.Data.GetType())
+	}
+	if !a.core.Limits().AllowPublish("", claims.Subject, req.Data.GetType()) {
+		return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+
+	event, err := core.ValidateEvent(pb.ToCore(req.Data), core.Validation)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if event, err = a.schemas.Admit(event, false); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	replies := make(chan core.CloudEvent, 1)
+	replyTo := a.core.ExpectReply(event.Type, time.Duration(req.TimeoutMs)*time.Millisecond, func(event core.CloudEvent, ok bool) {
+		if ok {
+			replies <- event
+		}
+		close(replies)
+	})
+
+	if event.Extensions == nil {
+		event.Extensions = make(map[string]string)
+	}
+	event.Extensions[core.ExtReplyTo] = replyTo
+
 	go func() {
-		for peerServer := range a.core.GetPeerServers() {
-			a.publishChannel <- &core.PeerEvent{
-				PeerServer: peerServer,
-				Event:      event,
-			}
+		for _, peerEvent := range a.core.PeerEvents(event) {
+			a.publishChannel <- peerEvent
 		}
 	}()
 
-	return &pb.EventPubResponse{SubscriptionId: req.SubscriptionId}, nil
+	select {
+	case reply, ok := <-replies:
+		if !ok {
+			return nil, status.Errorf(codes.DeadlineExceeded, "no reply to request on %s", event.Type)
+		}
+		return &pb.EventRequestResponse{Data: pb.FromCore(reply)}, nil
+	case <-ctx.Done():
+		a.core.CancelReply(replyTo)
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// Reply - Answers a request issued through this agent
+func (a *Adapter) Reply(ctx context.Context, req *pb.EventReplyRequest) (*pb.EventReplyResponse, error) {
+	claims, err := a.verifier.Verify(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	event, err := core.ValidateEvent(pb.ToCore(req.Data), core.Validation)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	switch err := a.core.Reply(req.ReplyTo, event, claims); err {
+	case nil:
+	case core.ErrUnauthorized:
+		a.logger.Warn("grpc::Adapter.Reply => %s denied reply to '%s'", claims.Subject, req.ReplyTo)
+		return nil, status.Error(codes.PermissionDenied, "not authorized to reply to this request")
+	default:
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &pb.EventReplyResponse{}, nil
+}
+
+// CancelScheduled - Drops an event the caller scheduled
+func (a *Adapter) CancelScheduled(ctx context.Context, req *pb.EventCancelRequest) (*pb.EventCancelResponse, error) {
+	claims, err := a.verifier.Verify(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err := a.scheduler.Cancel(claims.Subject, req.EventId); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &pb.EventCancelResponse{}, nil
+}
+
+func (a *Adapter) History(ctx context.Context, req *pb.EventHistoryRequest) (*pb.EventHistoryResponse, error) {
+	claims, err := a.verifier.Verify(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if !claims.CanSubscribe(req.Channel) {
+		a.logger.Warn("grpc::Adapter.History => %s denied history of '%s'", claims.Subject, req.Channel)
+		return nil, status.Errorf(codes.PermissionDenied, "not authorized to subscribe to %s", req.Channel)
+	}
+
+	query, err := core.ParseHistoryQuery(func(name string) string {
+		switch name {
+		case "since":
+			return req.Since
+		case "until":
+			return req.Until
+		case "after":
+			return req.After
+		case "limit":
+			if req.Limit > 0 {
+				return strconv.Itoa(int(req.Limit))
+			}
+		case "last":
+			if req.Last > 0 {
+				return strconv.Itoa(int(req.Last))
+			}
+		}
+		return ""
+	})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	page, err := a.history.History(req.Channel, query)
+	if errors.Is(err, core.ErrCursorExpired) {
+		return nil, status.Error(codes.OutOfRange, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	events := make([]*pb.CloudEvent, len(page.Events))
+	for i, event := range page.Events {
+		events[i] = pb.FromCore(event)
+	}
+
+	return &pb.EventHistoryResponse{Events: events, Next: page.Next}, nil
 }
 
 func (a *Adapter) Run() error {
@@ -0,0 +1,26 @@
+package websocket
+
+import (
+	"expvar"
+	"net/http"
+
+	"github.com/josh-tracey/scribe"
+)
+
+// Address the metrics listener binds to, ADMIN_ADDR. Loopback only by default
+// so counters are not served on the public WebSocket port; "off" disables it.
+var AdminAddr = getEnv("ADMIN_ADDR", "127.0.0.1:8081")
+
+// serveAdmin - Serves /debug/vars on its own mux and port
+func serveAdmin(logger *scribe.Logger) {
+	if AdminAddr == "off" || AdminAddr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	logger.Info("Admin Server Listening on %s", AdminAddr)
+	if err := http.ListenAndServe(AdminAddr, mux); err != nil {
+		logger.Error("websocket::serveAdmin => %s", err)
+	}
+}
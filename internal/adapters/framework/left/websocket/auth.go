@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+// Subprotocol clients offer alongside their token, e.g.
+// Sec-WebSocket-Protocol: bearer, <token>
+const bearerSubprotocol = "bearer"
+
+var (
+	authCookieName = getEnv("WS_AUTH_COOKIE", "token")
+	authQueryParam = getEnv("WS_AUTH_QUERY_PARAM", "token")
+
+	errMissingToken    = errors.New("missing token")
+	errTokenExpired    = errors.New("token expired")
+	errSubjectMismatch = errors.New("refreshed token subject does not match connection")
+)
+
+func getEnv(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// tokenFromRequest - Extracts a token from the upgrade request, checking the
+// Authorization header, Sec-WebSocket-Protocol, cookie and query parameter in
+// that order. subprotocol is set when the token came from the protocol header
+// and must be echoed back to the client.
+func tokenFromRequest(r *http.Request) (token string, subprotocol string) {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer "), ""
+	}
+
+	protocols := websocketProtocols(r)
+	for i, protocol := range protocols {
+		if protocol == bearerSubprotocol && i+1 < len(protocols) {
+			return protocols[i+1], bearerSubprotocol
+		}
+	}
+
+	if cookie, err := r.Cookie(authCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value, ""
+	}
+
+	return r.URL.Query().Get(authQueryParam), ""
+}
+
+func websocketProtocols(r *http.Request) []string {
+	var protocols []string
+	for _, header := range r.Header.Values("Sec-Websocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			protocols = append(protocols, strings.TrimSpace(protocol))
+		}
+	}
+	return protocols
+}
+
+// authenticate - Verifies a token and returns its claims
+func (p *Pool) authenticate(token string) (*core.Claims, error) {
+	if token == "" {
+		return nil, errMissingToken
+	}
+
+	claims, err := p.verifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.ExpiresAt.IsZero() && claims.ExpiresAt.Before(time.Now()) {
+		return nil, errTokenExpired
+	}
+
+	return claims, nil
+}
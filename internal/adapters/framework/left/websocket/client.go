@@ -3,35 +3,123 @@ package websocket
 import (
 	"encoding/json"
 	"errors"
-	"os"
+	"fmt"
+	"math"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
-	"github.com/josh-tracey/notary"
 )
 
 type Client struct {
 	core.CoreClient
-	ID     string
-	Conn   *websocket.Conn
-	Pool   *Pool
-	Send   chan interface{}
-	closed bool
-	cLock  *sync.RWMutex
+	ID      string
+	Conn    *websocket.Conn
+	Pool    *Pool
+	Send    *outbox
+	claims  *core.Claims
+	refs    map[string]string
+	renewed chan struct{}
+	closed  bool
+	cLock   *sync.RWMutex
+
+	violations      int
+	violationsSince time.Time
+
+	catchups map[string]*catchup
+	dLock    sync.Mutex
+
+	// Held across the ref check and update of subscribe and unsubscribe,
+	// which run on any pool worker, so a channel is subscribed at most once
+	sLock sync.Mutex
 }
 
 func (c *Client) isClient() {}
 
-func NewClient(id string, conn *websocket.Conn, pool *Pool) *Client {
+func NewClient(id string, conn *websocket.Conn, pool *Pool, claims *core.Claims, policy BackpressurePolicy) *Client {
 	return &Client{
-		ID:    id,
-		Conn:  conn,
-		Pool:  pool,
-		Send:  make(chan interface{}, 32),
-		cLock: &sync.RWMutex{},
+		ID:      id,
+		Conn:    conn,
+		Pool:    pool,
+		Send:    newOutbox(SendBufferSize, policy),
+		claims:  claims,
+		refs:    make(map[string]string),
+		renewed: make(chan struct{}, 1),
+		cLock:   &sync.RWMutex{},
+
+		catchups: make(map[string]*catchup),
+	}
+}
+
+// Identity - Claims of the token the client authenticated with
+func (c *Client) Identity() *core.Claims {
+	c.cLock.RLock()
+	defer c.cLock.RUnlock()
+	return c.claims
+}
+
+// ref - Ref ID of the client's subscription to channel
+func (c *Client) ref(channel string) (string, bool) {
+	c.cLock.RLock()
+	defer c.cLock.RUnlock()
+	refID, ok := c.refs[channel]
+	return refID, ok
+}
+
+func (c *Client) addRef(channel string, refID string) {
+	c.cLock.Lock()
+	defer c.cLock.Unlock()
+	c.refs[channel] = refID
+}
+
+func (c *Client) removeRef(channel string) (string, bool) {
+	c.cLock.Lock()
+	defer c.cLock.Unlock()
+	refID, ok := c.refs[channel]
+	delete(c.refs, channel)
+	return refID, ok
+}
+
+// channels - Channels the client is subscribed to
+func (c *Client) channels() []string {
+	c.cLock.RLock()
+	defer c.cLock.RUnlock()
+	channels := make([]string, 0, len(c.refs))
+	for channel := range c.refs {
+		channels = append(channels, channel)
 	}
+	return channels
+}
+
+// refresh - Swaps the client's identity for a renewed token of the same subject
+func (c *Client) refresh(token string) error {
+	claims, err := c.Pool.authenticate(token)
+	if err != nil {
+		return err
+	}
+
+	c.cLock.Lock()
+	defer c.cLock.Unlock()
+	if claims.Subject != c.claims.Subject {
+		return errSubjectMismatch
+	}
+	c.claims = claims
+
+	select {
+	case c.renewed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// tokenTTL - Time left until the client's token expires
+func (c *Client) tokenTTL() time.Duration {
+	claims := c.Identity()
+	if claims == nil || claims.ExpiresAt.IsZero() {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Until(claims.ExpiresAt)
 }
 
 func (c *Client) close() {
@@ -45,8 +133,13 @@ func (c *Client) close() {
 		if err := c.Conn.Close(); err != nil {
 			c.Pool.Logging.Trace("websocket was already closed: %+v", err)
 		}
-		close(c.Send)
+		unsent := c.Send.close()
 		c.closed = true
+		c.Pool.core.Limits().Forget(c.ID)
+		c.Pool.inboxes.remove(c, c.claims.Subject)
+		if len(unsent) > 0 {
+			go c.Pool.redistribute(unsent)
+		}
 	}
 }
 
@@ -59,8 +152,6 @@ var (
 	PingPeriod = (PongWait * 9) / 10
 	// Maximum message size allowed from peer.
 	MaxMessageSize int64 = 64 * 1024
-
-	jwtTokenSecret = os.Getenv("JWT_TOKEN_SECRET")
 )
 
 func dispatch(c *Client, data map[string]interface{}) error {
@@ -73,44 +164,279 @@ func dispatch(c *Client, data map[string]interface{}) error {
 
 	defer c.Pool.Logging.Duration(time.Now(), "Client::ReadListen::dispatch")
 
-	token, ok := data["token"].(string)
+	id := correlationID(data)
 
-	if !ok {
-		c.Pool.Logging.Error("websocket::Client.dispatch => invalid or missing token")
-		return errors.New("invalid or missing token")
+	claims := c.Identity()
+	if claims == nil {
+		c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidToken, errMissingToken.Error()))
+		return errMissingToken
 	}
 
-	valid, err := notary.New(jwtTokenSecret).VerifyToken(token)
-	if err != nil {
-		c.Pool.Logging.Error("websocket::Client.dispatch => %s", err)
-		return err
-	}
-	if !valid {
-		c.Pool.Logging.Error("websocket::Client.dispatch => invalid token")
-		return errors.New("invalid token")
-	}
+	msgType, _ := data["type"].(string)
 
-	if valid {
-
-		switch data["type"].(string) {
-		case "publish":
-			c.Pool.Logging.Trace("dispatch => publish")
-			request := *c.NewPublishRequest(data)
-			c.Pool.Publish <- request
-		case "subscribe":
-			c.Pool.Logging.Trace("dispatch => subscribe")
-			c.Pool.Subscribe <- *c.NewSubscribeRequest(data)
-		case "unsubscribe":
-			c.Pool.Logging.Trace("dispatch => unsubscribe")
-			c.Pool.Unsubscribe <- *c.NewSubscribeRequest(data)
-		default:
-			c.Pool.Logging.Trace("dispatch => invalid request")
-			c.Conn.WriteJSON("Invalid Request")
+	switch msgType {
+	case "refresh":
+		c.Pool.Logging.Trace("dispatch => refresh")
+		token, _ := data["token"].(string)
+		if err := c.refresh(token); err != nil {
+			c.Pool.Logging.Warn("websocket::Client.dispatch => token refresh failed for %s: %s", claims.Subject, err)
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidToken, err.Error()))
+			return nil
+		}
+		c.reply(core.AckMessage{Type: core.AckRefreshed, ID: id})
+	case "publish", "request":
+		c.Pool.Logging.Trace("dispatch => %s", msgType)
+		request, malformed := c.NewPublishRequest(data)
+		if request == nil {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, "malformed publish request"))
+			return nil
+		}
+		if !c.validEvent(id, &request.Event, malformed) {
+			return nil
 		}
+		if !claims.CanPublish(request.Event.Type) {
+			c.Pool.Logging.Warn("websocket::Client.dispatch => %s denied publish to '%s'", claims.Subject, request.Event.Type)
+			c.reply(core.NewErrorMessage(id, core.ErrCodeUnauthorized, "not authorized to publish to "+request.Event.Type))
+			return nil
+		}
+		if !c.Pool.core.Limits().AllowPublish(c.ID, claims.Subject, request.Event.Type) {
+			return c.rateLimited(id)
+		}
+		// Requests wait on a reply, so only publishes are quarantined
+		admitted, err := c.Pool.schemas.Admit(request.Event, msgType == "publish")
+		if err != nil {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeSchemaViolation, err.Error()))
+			return nil
+		}
+		request.Event = admitted
+		stamped, err := core.StampExpiry(request.Event, time.Now())
+		if err != nil {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, err.Error()))
+			return nil
+		}
+		if msgType == "publish" {
+			at, scheduled, err := core.DeliverAt(request.Event, time.Now())
+			if err != nil {
+				c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, err.Error()))
+				return nil
+			}
+			pending := core.ScheduledEvent{
+				Subject:   claims.Subject,
+				Channel:   request.Channel,
+				Retain:    request.Retain,
+				DeliverAt: at,
+				Event:     request.Event,
+			}
+			// Rescheduling a pending event replaces it rather than being
+			// taken for a duplicate of it
+			if scheduled && c.Pool.scheduler.Replace(pending) {
+				c.reply(core.AckMessage{Type: core.AckScheduled, ID: id, Channel: request.Event.Type})
+				return nil
+			}
+			if c.Pool.core.Duplicate(request.Event, "websocket") {
+				c.reply(core.AckMessage{Type: core.AckPublished, ID: id, Channel: request.Event.Type, Duplicate: true})
+				return nil
+			}
+			if scheduled {
+				c.Pool.scheduler.Schedule(pending)
+				c.reply(core.AckMessage{Type: core.AckScheduled, ID: id, Channel: request.Event.Type})
+				return nil
+			}
+		}
+		request.Event = stamped
+		c.Pool.Publish(*request)
+	case "reply":
+		c.Pool.Logging.Trace("dispatch => reply")
+		replyTo, _ := data["replyTo"].(string)
+		event, malformed := c.NewReplyEvent(data)
+		if replyTo == "" || event == nil {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, "malformed reply"))
+			return nil
+		}
+		if !c.validEvent(id, event, malformed) {
+			return nil
+		}
+		if err := c.Pool.core.Reply(replyTo, *event, claims); err == core.ErrUnauthorized {
+			c.Pool.Logging.Warn("websocket::Client.dispatch => %s denied reply to '%s'", claims.Subject, replyTo)
+			c.reply(core.NewErrorMessage(id, core.ErrCodeUnauthorized, "not authorized to reply to this request"))
+			return nil
+		} else if err != nil {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, err.Error()))
+			return nil
+		}
+		c.reply(core.AckMessage{Type: core.AckReplied, ID: id})
+	case "direct":
+		c.Pool.Logging.Trace("dispatch => direct")
+		request, malformed := c.NewDirectRequest(data)
+		if request == nil || (request.User == "") == (request.Connection == "") {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, "direct request needs exactly one of user or connection"))
+			return nil
+		}
+		if !c.validEvent(id, &request.Event, malformed) {
+			return nil
+		}
+		user := request.User
+		if request.Connection != "" {
+			target := c.Pool.inboxes.connection(request.Connection)
+			if target == nil {
+				c.reply(core.NewErrorMessage(id, core.ErrCodeNotConnected, "recipient is not connected"))
+				return nil
+			}
+			user = target.Identity().Subject
+		}
+		if !claims.CanPublish(core.InboxChannel(user)) {
+			c.Pool.Logging.Warn("websocket::Client.dispatch => %s denied direct message to '%s'", claims.Subject, user)
+			c.reply(core.NewErrorMessage(id, core.ErrCodeUnauthorized, "not authorized to message "+user))
+			return nil
+		}
+		if !c.Pool.core.Limits().AllowPublish(c.ID, claims.Subject, core.InboxChannel(user)) {
+			return c.rateLimited(id)
+		}
+		c.Pool.Direct <- *request
+	case "subscribe":
+		c.Pool.Logging.Trace("dispatch => subscribe")
+		request := c.NewSubscribeRequest(data)
+		if request == nil {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, "malformed subscribe request"))
+			return nil
+		}
+		if request.Durable != "" && request.Group != "" {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, "durable subscriptions cannot join a group"))
+			return nil
+		}
+		for _, channel := range request.Channels {
+			if !claims.CanSubscribe(channel) {
+				c.Pool.Logging.Warn("websocket::Client.dispatch => %s denied subscribe to '%s'", claims.Subject, channel)
+				c.reply(core.NewErrorMessage(id, core.ErrCodeUnauthorized, "not authorized to subscribe to "+channel))
+				return nil
+			}
+		}
+		if !c.Pool.core.Limits().SubscribeFits(request.Channels...) {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, "too many channels per subscribe"))
+			return nil
+		}
+		if !c.Pool.core.Limits().AllowSubscribe(c.ID, claims.Subject, request.Channels...) {
+			return c.rateLimited(id)
+		}
+		c.Pool.Subscribe <- *request
+	case "clear-retained":
+		c.Pool.Logging.Trace("dispatch => clear-retained")
+		channel, _ := data["channel"].(string)
+		if channel == "" {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, "missing channel"))
+			return nil
+		}
+		if !claims.CanPublish(channel) {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeUnauthorized, "not authorized to publish to "+channel))
+			return nil
+		}
+		c.Pool.core.ClearRetained(channel)
+		c.reply(core.AckMessage{Type: core.AckCleared, ID: id, Channel: channel})
+	case "history":
+		c.Pool.Logging.Trace("dispatch => history")
+		channel, _ := data["channel"].(string)
+		if !claims.CanSubscribe(channel) {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeUnauthorized, "not authorized to subscribe to "+channel))
+			return nil
+		}
+		query, err := core.ParseHistoryQuery(func(name string) string {
+			if value, ok := data[name]; ok && value != nil {
+				return fmt.Sprint(value)
+			}
+			return ""
+		})
+		if err != nil {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, err.Error()))
+			return nil
+		}
+		page, err := c.Pool.history.History(channel, query)
+		if errors.Is(err, core.ErrCursorExpired) {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeCursorExpired, err.Error()))
+			return nil
+		}
+		if err != nil {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, err.Error()))
+			return nil
+		}
+		c.reply(core.HistoryMessage{Type: "history", ID: id, Channel: channel, Events: page.Events, Next: page.Next})
+	case "members":
+		c.Pool.Logging.Trace("dispatch => members")
+		channel, _ := data["channel"].(string)
+		if !core.IsPresenceChannel(channel) {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, channel+" is not a presence channel"))
+			return nil
+		}
+		if !claims.CanSubscribe(channel) {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeUnauthorized, "not authorized to subscribe to "+channel))
+			return nil
+		}
+		c.reply(core.MembersMessage{Type: "members", ID: id, Channel: channel, Members: c.Pool.core.Members(channel)})
+	case "cancel-scheduled":
+		c.Pool.Logging.Trace("dispatch => cancel-scheduled")
+		eventID, _ := data["event"].(string)
+		if err := c.Pool.scheduler.Cancel(claims.Subject, eventID); err != nil {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, err.Error()))
+			return nil
+		}
+		c.reply(core.AckMessage{Type: core.AckCancelled, ID: id})
+	case "durables":
+		c.Pool.Logging.Trace("dispatch => durables")
+		c.reply(core.DurablesMessage{Type: "durables", ID: id, Durables: c.Pool.durables.List(claims.Subject)})
+	case "rewind":
+		c.Pool.Logging.Trace("dispatch => rewind")
+		name, _ := data["durable"].(string)
+		channel, _ := data["channel"].(string)
+		cursor, err := parseCursor(data)
+		if name == "" || err != nil {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, "malformed rewind request"))
+			return nil
+		}
+		c.Pool.rewind(c, id, name, channel, cursor)
+	case "delete-durable":
+		c.Pool.Logging.Trace("dispatch => delete-durable")
+		name, _ := data["durable"].(string)
+		if !c.Pool.durables.Delete(claims.Subject, name) {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, core.ErrDurableNotFound.Error()))
+			return nil
+		}
+		c.reply(core.AckMessage{Type: core.AckDeleted, ID: id})
+	case "unsubscribe":
+		c.Pool.Logging.Trace("dispatch => unsubscribe")
+		request := c.NewSubscribeRequest(data)
+		if request == nil {
+			c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, "malformed unsubscribe request"))
+			return nil
+		}
+		c.Pool.Unsubscribe <- *request
+	default:
+		c.Pool.Logging.Trace("dispatch => invalid request")
+		c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, "unknown request type"))
 	}
 	return nil
 }
 
+// reply - Queues a response frame for the client without blocking the caller
+func (c *Client) reply(msg core.Message) {
+	c.send(msg)
+}
+
+// send - Queues a frame without blocking, disconnecting clients whose
+// backpressure policy asks for it once their buffer is full
+func (c *Client) send(frame interface{}) {
+	if c.Send.push(frame) {
+		return
+	}
+
+	c.Pool.Logging.Warn("websocket::Client.send => disconnecting slow consumer %s", c.ID)
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "slow consumer")
+	if err := c.Conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(WriteWait)); err != nil {
+		c.Pool.Logging.Trace("failed to write close message: %+v", err)
+	}
+	c.cLock.Lock()
+	c.close()
+	c.cLock.Unlock()
+}
+
 func (c *Client) WriteListen() {
 
 	write := func(mt int, payload interface{}, json bool) error {
@@ -126,11 +452,13 @@ func (c *Client) WriteListen() {
 	}
 
 	ticker := time.NewTicker(PingPeriod)
+	expiry := time.NewTimer(c.tokenTTL())
 	defer func() {
 		if err := recover(); err != nil {
 			c.Pool.Logging.Error("websocket::Client.WriteListen => %s", err)
 		}
 		ticker.Stop()
+		expiry.Stop()
 		c.cLock.Lock()
 		c.close()
 		c.cLock.Unlock()
@@ -138,22 +466,46 @@ func (c *Client) WriteListen() {
 
 	for {
 		select {
-		case message, ok := <-c.Send:
+		case _, ok := <-c.Send.ready:
 			if !ok {
-				if err := write(websocket.CloseMessage, []byte{}, false); err != nil {
-					c.Pool.Logging.Trace("socket already closed: %+v", err)
+				return
+			}
+			messages, missed := c.Send.drain()
+			if missed > 0 {
+				if err := write(websocket.TextMessage, core.MissedMessage{Type: "missed", Count: missed}, true); err != nil {
+					c.Pool.Logging.Trace("failed to write socket message: %+v", err)
 					panic(err)
 				}
 			}
-			if err := write(websocket.TextMessage, message, true); err != nil {
-				c.Pool.Logging.Trace("failed to write socket message: %+v", err)
-				panic(err)
+			for _, message := range messages {
+				if err := write(websocket.TextMessage, payload(message), true); err != nil {
+					c.Pool.Logging.Trace("failed to write socket message: %+v", err)
+					panic(err)
+				}
+				if f, ok := message.(eventFrame); ok && f.durable != "" {
+					c.Pool.durables.Advance(c.Identity().Subject, f.durable, f.channel, f.event)
+				}
 			}
 		case <-ticker.C:
 			if err := write(websocket.PingMessage, []byte{}, false); err != nil {
 				c.Pool.Logging.Trace("failed to ping socket: %+v", err)
 				panic(err)
 			}
+		case <-c.renewed:
+			if !expiry.Stop() {
+				select {
+				case <-expiry.C:
+				default:
+				}
+			}
+			expiry.Reset(c.tokenTTL())
+		case <-expiry.C:
+			c.Pool.Logging.Trace("websocket::Client.WriteListen => token expired for %s, closing", c.ID)
+			closeMessage := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, errTokenExpired.Error())
+			if err := c.Conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(WriteWait)); err != nil {
+				c.Pool.Logging.Trace("failed to write close message: %+v", err)
+			}
+			return
 		}
 	}
 }
@@ -0,0 +1,77 @@
+package websocket
+
+import (
+	"sync"
+
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+// inboxes - Connected clients by connection ID and by token subject, used to
+// deliver direct messages
+type inboxes struct {
+	connections map[string]*Client
+	subjects    map[string]map[*Client]struct{}
+	lock        sync.RWMutex
+}
+
+func newInboxes() *inboxes {
+	return &inboxes{
+		connections: make(map[string]*Client),
+		subjects:    make(map[string]map[*Client]struct{}),
+	}
+}
+
+func (i *inboxes) add(c *Client, subject string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.connections[c.ID] = c
+	if i.subjects[subject] == nil {
+		i.subjects[subject] = make(map[*Client]struct{})
+	}
+	i.subjects[subject][c] = struct{}{}
+}
+
+func (i *inboxes) remove(c *Client, subject string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.connections[c.ID] == c {
+		delete(i.connections, c.ID)
+	}
+	delete(i.subjects[subject], c)
+	if len(i.subjects[subject]) == 0 {
+		delete(i.subjects, subject)
+	}
+}
+
+// connection - Client connected with ID
+func (i *inboxes) connection(id string) *Client {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	return i.connections[id]
+}
+
+// recipients - Clients a direct event is addressed to, a connection when one
+// is given and otherwise every connection of the user
+func (p *Pool) recipients(r core.DirectEvent) []*Client {
+	if r.Connection != "" {
+		if c := p.inboxes.connection(r.Connection); c != nil {
+			return []*Client{c}
+		}
+		return nil
+	}
+	return p.inboxes.user(r.User)
+}
+
+// user - Connected clients authenticated as subject
+func (i *inboxes) user(subject string) []*Client {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	clients := make([]*Client, 0, len(i.subjects[subject]))
+	for c := range i.subjects[subject] {
+		clients = append(clients, c)
+	}
+	return clients
+}
@@ -0,0 +1,194 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+// catchup - Live events of a durable subscription held back while its
+// backlog is replayed, so they are written after the events that came before
+type catchup struct {
+	frames []eventFrame
+}
+
+// deliverDurable - Sends a live durable event, or holds it while the
+// subscription is catching up
+func (c *Client) deliverDurable(f eventFrame) {
+	c.dLock.Lock()
+	if pending, ok := c.catchups[f.durable]; ok {
+		pending.frames = append(pending.frames, f)
+		c.dLock.Unlock()
+		return
+	}
+	c.dLock.Unlock()
+	c.send(f)
+}
+
+// startCatchup - Holds live events of a durable subscription until its replay
+// finishes, returning false if a replay is already running
+func (c *Client) startCatchup(durable string) bool {
+	c.dLock.Lock()
+	defer c.dLock.Unlock()
+	if _, ok := c.catchups[durable]; ok {
+		return false
+	}
+	c.catchups[durable] = &catchup{}
+	return true
+}
+
+// durableSubscribe - Subscribes a client to channels for a durable
+// subscription. Returns the ref ID of each channel's subscription.
+func (p *Pool) durableSubscribe(c *Client, channels []string, durable string) map[string]string {
+	c.sLock.Lock()
+	defer c.sLock.Unlock()
+
+	refs := make(map[string]string, len(channels))
+	for _, channel := range channels {
+		refID, ok := c.ref(channel)
+		if !ok {
+			refID = p.core.AddDurablePeer(c.ID, channel, durable)
+			p.addClient(refID, c)
+			c.addRef(channel, refID)
+		}
+		refs[channel] = refID
+	}
+	return refs
+}
+
+// replay - Sends a durable subscription's events after its cursors, waiting
+// for room in the client's buffer rather than dropping them, then releases
+// the live events held back meanwhile. Must follow a successful startCatchup.
+func (p *Pool) replay(c *Client, d core.Durable, channels []string) {
+	defer func() {
+		if err := recover(); err != nil {
+			p.Logging.Error("websocket::Pool.replay => unhandled exception: %+v", err)
+		}
+	}()
+
+	sent := make(map[string]bool)
+	deliver := func(f eventFrame) bool {
+		key := f.channel + "\x00" + f.event.ID
+		if sent[key] {
+			return true
+		}
+		if !c.Send.waitRoom(PongWait) {
+			return false
+		}
+		sent[key] = true
+		c.send(f)
+		return true
+	}
+
+	for _, channel := range channels {
+		query := d.Cursors[channel].Query()
+		for {
+			page, err := p.history.History(channel, query)
+			if errors.Is(err, core.ErrCursorExpired) {
+				// Replaying from the oldest event instead would repeat what
+				// was already delivered, leave it to the client to rewind
+				c.reply(core.NewErrorMessage("", core.ErrCodeCursorExpired, fmt.Sprintf("durable %s cursor on %s expired, rewind to resume", d.Name, channel)))
+				break
+			}
+			if err != nil {
+				p.Logging.Error("websocket::Pool.replay => %s", err)
+				break
+			}
+			for _, event := range page.Events {
+				if !deliver(eventFrame{channel: channel, durable: d.Name, event: event}) {
+					c.stopCatchup(d.Name)
+					return
+				}
+			}
+			if page.Next == "" {
+				break
+			}
+			query.After = page.Next
+		}
+	}
+
+	for {
+		c.dLock.Lock()
+		pending := c.catchups[d.Name]
+		held := pending.frames
+		pending.frames = nil
+		if len(held) == 0 {
+			delete(c.catchups, d.Name)
+			c.dLock.Unlock()
+			return
+		}
+		c.dLock.Unlock()
+
+		for _, f := range held {
+			if !deliver(f) {
+				c.stopCatchup(d.Name)
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) stopCatchup(durable string) {
+	c.dLock.Lock()
+	defer c.dLock.Unlock()
+	delete(c.catchups, durable)
+}
+
+// resume - Opens a durable subscription, subscribes the client to its
+// channels and replays what it missed
+func (p *Pool) resume(c *Client, r core.SubscribeRequest[*Client]) {
+	subject := c.Identity().Subject
+	d := p.durables.Open(subject, r.Durable, r.Channels)
+
+	catchingUp := c.startCatchup(r.Durable)
+	refs := p.durableSubscribe(c, r.Channels, r.Durable)
+	c.reply(core.AckMessage{
+		Type:     core.AckSubscribed,
+		ID:       r.ID,
+		Channels: r.Channels,
+		Refs:     refs,
+	})
+	p.join(c, r.Channels, r.Meta)
+
+	if catchingUp {
+		go p.replay(c, d, r.Channels)
+	}
+}
+
+// rewind - Moves a durable subscription's cursors back and, when the client
+// is subscribed, replays from the new position
+func (p *Pool) rewind(c *Client, id string, name string, channel string, to core.Cursor) {
+	d, err := p.durables.Rewind(c.Identity().Subject, name, channel, to)
+	if err != nil {
+		c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidRequest, err.Error()))
+		return
+	}
+	c.reply(core.AckMessage{Type: core.AckRewound, ID: id, Channel: channel})
+
+	var active []string
+	for ch := range d.Cursors {
+		if _, ok := c.ref(ch); ok && (channel == "" || ch == channel) {
+			active = append(active, ch)
+		}
+	}
+	if len(active) > 0 && c.startCatchup(name) {
+		go p.replay(c, d, active)
+	}
+}
+
+// parseCursor - Position a rewind frame asks for: after a history cursor,
+// since a time, or the start of the retained log when neither is given
+func parseCursor(data map[string]interface{}) (core.Cursor, error) {
+	var cursor core.Cursor
+	cursor.After, _ = data["after"].(string)
+	if since, ok := data["since"].(string); ok && since != "" {
+		t, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			return cursor, err
+		}
+		cursor.Since = t
+	}
+	return cursor, nil
+}
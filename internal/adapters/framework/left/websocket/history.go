@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+// serveHistory - GET /history?channel=<channel> returning stored events for
+// the channel, filtered by since, until, after, limit and last
+func serveHistory(pool *Pool, w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			pool.Logging.Error("websocket::Pool.serveHistory => %s", r)
+		}
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, _ := tokenFromRequest(r)
+	claims, err := pool.authenticate(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	channel := r.URL.Query().Get("channel")
+	if channel == "" {
+		http.Error(w, "missing channel", http.StatusBadRequest)
+		return
+	}
+	if !claims.CanSubscribe(channel) {
+		http.Error(w, "not authorized to subscribe to "+channel, http.StatusForbidden)
+		return
+	}
+
+	query, err := core.ParseHistoryQuery(r.URL.Query().Get)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := pool.history.History(channel, query)
+	if errors.Is(err, core.ErrCursorExpired) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(core.HistoryMessage{Type: "history", Channel: channel, Events: page.Events, Next: page.Next})
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/google/uuid"
 	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
 	"github.com/josh-tracey/eventual-agent/internal/ports"
 	"github.com/josh-tracey/scribe"
@@ -13,9 +14,16 @@ import (
 type Adapter struct {
 	core           *core.Adapter
 	grpcEventQueue chan *core.CloudEvent
+	publishChannel chan *core.PeerEvent
+	inboxChannel   chan *core.PeerEvent
+	verifier       ports.TokenVerifier
+	history        ports.MessageQueuePort
+	durables       *core.DurableRegistry
+	scheduler      *core.Scheduler
+	schemas        *core.SchemaRegistry
 }
 
-func NewAdapter(c ports.SubjectPort, grpcEventQueue chan *core.CloudEvent) *Adapter {
+func NewAdapter(c ports.SubjectPort, grpcEventQueue chan *core.CloudEvent, publishChannel chan *core.PeerEvent, inboxChannel chan *core.PeerEvent, verifier ports.TokenVerifier, history ports.MessageQueuePort, durables *core.DurableRegistry, scheduler *core.Scheduler, schemas *core.SchemaRegistry) *Adapter {
 	value, ok := c.(*core.Adapter)
 	if !ok {
 		c.GetLogger().Error("websocket::Adapter.NewAdapter => Failed to cast c to *core.Adapter")
@@ -23,13 +31,22 @@ func NewAdapter(c ports.SubjectPort, grpcEventQueue chan *core.CloudEvent) *Adap
 	return &Adapter{
 		core:           value,
 		grpcEventQueue: grpcEventQueue,
+		publishChannel: publishChannel,
+		inboxChannel:   inboxChannel,
+		verifier:       verifier,
+		history:        history,
+		durables:       durables,
+		scheduler:      scheduler,
+		schemas:        schemas,
 	}
 }
 
 func (a *Adapter) ListenAndServe() {
-	setupRoutes(a)
+	mux := http.NewServeMux()
+	setupRoutes(a, mux)
+	go serveAdmin(a.core.GetLogger())
 	a.core.GetLogger().Info("Websocket Server Listening on 0.0.0.0:8080")
-	err := http.ListenAndServe(":8080", nil)
+	err := http.ListenAndServe(":8080", mux)
 	if err != nil {
 		log.Fatal(scribe.FgRed, "Fatal: ", scribe.Reset, err)
 	}
@@ -42,25 +59,65 @@ func serveWs(pool *Pool, w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	ws, err := Upgrade(w, r)
+	token, subprotocol := tokenFromRequest(r)
+	claims, err := pool.authenticate(token)
+	if err != nil {
+		pool.Logging.Warn("websocket authentication failed for %s: %+v", r.RemoteAddr, err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if !pool.origins.Check(r, claims) {
+		pool.Logging.Warn("websocket origin '%s' rejected for %s", r.Header.Get("Origin"), r.RemoteAddr)
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	policy, err := ParseBackpressurePolicy(r.URL.Query().Get("backpressure"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	header := http.Header{}
+	if subprotocol != "" {
+		header.Set("Sec-Websocket-Protocol", subprotocol)
+	}
+
+	ws, err := Upgrade(w, r, header)
 	if err != nil {
 		fmt.Fprintf(w, "%+v", err)
 		pool.Logging.Warn("websocket upgrade failed: %+v", (err.Error()))
+		return
 	}
 
-	client := NewClient(r.RemoteAddr, ws, pool)
-	pool.Logging.Trace("Received Connection from %+v", client)
+	// Opaque so presence and direct messages never reveal the peer address
+	client := NewClient(uuid.NewString(), ws, pool, claims, policy)
+	pool.inboxes.add(client, claims.Subject)
+	pool.Logging.Trace("Received Connection %s from %s", client.ID, r.RemoteAddr)
 	go client.WriteListen()
 	client.ReadListen()
 }
 
-func setupRoutes(a *Adapter) {
-	pool := NewPool(a.core, a.grpcEventQueue)
-	for i := 1; i <= 32; i++ {
-		go pool.Start()
+func setupRoutes(a *Adapter, mux *http.ServeMux) {
+	origins, err := LoadOriginPolicy()
+	if err != nil {
+		log.Fatal(scribe.FgRed, "Fatal: ", scribe.Reset, err)
+	}
+
+	pool := NewPool(a.core, a.grpcEventQueue, a.publishChannel, a.inboxChannel, a.verifier, origins, a.history, a.durables, a.scheduler, a.schemas)
+	for i := 0; i < PoolWorkers; i++ {
+		go pool.Start(i)
 	}
 	go pool.Cleaner()
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	go a.scheduler.Run(pool.publishScheduled, pool.Logging)
+	mux.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+		serveHistory(pool, w, r)
+	})
+	mux.HandleFunc("/schemas", func(w http.ResponseWriter, r *http.Request) {
+		serveSchemas(pool, w, r)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		serveWs(pool, w, r)
 	})
 }
@@ -0,0 +1,206 @@
+package websocket
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+var (
+	allowedOrigins   = os.Getenv("WS_ALLOWED_ORIGINS")
+	originPolicyFile = os.Getenv("WS_ORIGIN_POLICY_FILE")
+	tenantClaim      = getEnv("WS_TENANT_CLAIM", "tenant")
+
+	// Rejected upgrades keyed by the list that refused them, "default" or
+	// "tenant:<name>". Published on /debug/vars.
+	originRejections = expvar.NewMap("websocket_origin_rejections")
+)
+
+// OriginPolicy - Decides which browser origins may open sockets. Entries are
+// either exact origins ("https://app.example.com"), wildcard subdomains
+// ("https://*.example.com"), regular expressions prefixed with "re:" or "*"
+// for any origin. Tenants with their own list are checked against it instead
+// of the default list. Without any configuration only same host origins are
+// accepted.
+type OriginPolicy struct {
+	defaults *originList
+	tenants  map[string]*originList
+}
+
+type originList struct {
+	any        bool
+	exact      map[string]bool
+	subdomains []parentOrigin
+	patterns   []*regexp.Regexp
+}
+
+// parentOrigin - Wildcard subdomain entry, scheme empty when any is allowed
+type parentOrigin struct {
+	scheme string
+	host   string
+}
+
+// originPolicyConfig - Format of WS_ORIGIN_POLICY_FILE
+type originPolicyConfig struct {
+	Origins []string            `json:"origins"`
+	Tenants map[string][]string `json:"tenants"`
+}
+
+// LoadOriginPolicy - Builds the policy from WS_ORIGIN_POLICY_FILE and
+// WS_ALLOWED_ORIGINS (comma separated)
+func LoadOriginPolicy() (*OriginPolicy, error) {
+	var config originPolicyConfig
+
+	if originPolicyFile != "" {
+		raw, err := os.ReadFile(originPolicyFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &config); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", originPolicyFile, err)
+		}
+	}
+
+	for _, origin := range strings.Split(allowedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			config.Origins = append(config.Origins, origin)
+		}
+	}
+
+	return NewOriginPolicy(config.Origins, config.Tenants)
+}
+
+func NewOriginPolicy(origins []string, tenants map[string][]string) (*OriginPolicy, error) {
+	policy := &OriginPolicy{tenants: make(map[string]*originList, len(tenants))}
+
+	var err error
+	if len(origins) > 0 {
+		if policy.defaults, err = newOriginList(origins); err != nil {
+			return nil, err
+		}
+	}
+	for tenant, entries := range tenants {
+		if policy.tenants[tenant], err = newOriginList(entries); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+	}
+
+	return policy, nil
+}
+
+func newOriginList(entries []string) (*originList, error) {
+	list := &originList{exact: map[string]bool{}}
+	for _, entry := range entries {
+		switch {
+		case entry == "*":
+			list.any = true
+		case strings.HasPrefix(entry, "re:"):
+			// Anchored so the pattern has to match the whole origin
+			pattern, err := regexp.Compile("^(?:" + strings.TrimPrefix(entry, "re:") + ")$")
+			if err != nil {
+				return nil, err
+			}
+			list.patterns = append(list.patterns, pattern)
+		case strings.Contains(entry, "*."):
+			parent, err := parseParentOrigin(entry)
+			if err != nil {
+				return nil, err
+			}
+			list.subdomains = append(list.subdomains, parent)
+		default:
+			list.exact[strings.ToLower(strings.TrimSuffix(entry, "/"))] = true
+		}
+	}
+	return list, nil
+}
+
+// parseParentOrigin - Splits "https://*.example.com" or "*.example.com" by
+// hand, url.Parse reads a schemeless entry as a path
+func parseParentOrigin(entry string) (parentOrigin, error) {
+	var parent parentOrigin
+	host := entry
+	if i := strings.Index(entry, "://"); i >= 0 {
+		parent.scheme, host = strings.ToLower(entry[:i]), entry[i+3:]
+	}
+	host = strings.TrimSuffix(host, "/")
+	if !strings.HasPrefix(host, "*.") || len(host) == 2 || strings.ContainsAny(host[2:], "*/") {
+		return parent, fmt.Errorf("invalid wildcard origin %q", entry)
+	}
+	parent.host = strings.ToLower(host[2:])
+	return parent, nil
+}
+
+func (l *originList) allows(origin string) bool {
+	if l.any || l.exact[strings.ToLower(origin)] {
+		return true
+	}
+	for _, pattern := range l.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	for _, parent := range l.subdomains {
+		if (parent.scheme == "" || parent.scheme == strings.ToLower(u.Scheme)) &&
+			strings.HasSuffix(strings.ToLower(u.Host), "."+parent.host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check - Reports whether the request's Origin is allowed for the
+// authenticated client. Requests without an Origin are not from browsers and
+// are always allowed.
+func (p *OriginPolicy) Check(r *http.Request, claims *core.Claims) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	list, key := p.defaults, "default"
+	if tenant := tenantOf(claims); tenant != "" {
+		if tenantList, ok := p.tenants[tenant]; ok {
+			list, key = tenantList, "tenant:"+tenant
+		}
+	}
+
+	if list == nil {
+		if sameHost(origin, r.Host) {
+			return true
+		}
+	} else if list.allows(origin) {
+		return true
+	}
+
+	originRejections.Add(key, 1)
+	return false
+}
+
+func tenantOf(claims *core.Claims) string {
+	if claims == nil {
+		return ""
+	}
+	value, _ := claims.Get(tenantClaim)
+	tenant, _ := value.(string)
+	return tenant
+}
+
+func sameHost(origin string, host string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, host)
+}
@@ -0,0 +1,296 @@
+package websocket
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+// BackpressurePolicy - What happens to a client's outbound frames once its
+// send buffer is full
+type BackpressurePolicy string
+
+const (
+	// DropOldest - Evicts the oldest pending event to make room
+	DropOldest BackpressurePolicy = "drop-oldest"
+	// DropNewest - Discards the incoming frame
+	DropNewest BackpressurePolicy = "drop-newest"
+	// Coalesce - Replaces a pending event with the same type and subject,
+	// falling back to DropOldest
+	Coalesce BackpressurePolicy = "coalesce"
+	// Disconnect - Closes the connection
+	Disconnect BackpressurePolicy = "disconnect"
+)
+
+var (
+	DefaultBackpressure = BackpressurePolicy(getEnv("WS_BACKPRESSURE_POLICY", string(DropOldest)))
+	SendBufferSize      = getEnvInt("WS_SEND_BUFFER", 32)
+
+	// Slow consumer outcomes keyed by policy, published on /debug/vars
+	slowConsumerEvents = expvar.NewMap("websocket_slow_consumer")
+)
+
+func init() {
+	if SendBufferSize <= 0 {
+		panic(fmt.Sprintf("Invalid WS_SEND_BUFFER: %d, must be positive", SendBufferSize))
+	}
+}
+
+func ParseBackpressurePolicy(value string) (BackpressurePolicy, error) {
+	switch policy := BackpressurePolicy(value); policy {
+	case DropOldest, DropNewest, Coalesce, Disconnect:
+		return policy, nil
+	case "":
+		return DefaultBackpressure, nil
+	}
+	return "", fmt.Errorf("unknown backpressure policy '%s'", value)
+}
+
+// eventFrame - Event delivered on behalf of a consumer group or durable
+// subscription. Group events are handed to another member if the connection
+// closes before they are written, durable events advance the subscription's
+// cursor once written.
+type eventFrame struct {
+	channel string
+	group   string
+	durable string
+	event   core.CloudEvent
+}
+
+// eventOf - Event carried by a frame, if any
+func eventOf(frame interface{}) (core.CloudEvent, bool) {
+	switch f := frame.(type) {
+	case core.CloudEvent:
+		return f, true
+	case eventFrame:
+		return f.event, true
+	}
+	return core.CloudEvent{}, false
+}
+
+// durableOf - Whether a frame is a durable subscription's event, which is
+// never dropped as its cursor would advance past the gap
+func durableOf(frame interface{}) bool {
+	f, ok := frame.(eventFrame)
+	return ok && f.durable != ""
+}
+
+// payload - What is written to the socket for a frame
+func payload(frame interface{}) interface{} {
+	if f, ok := frame.(eventFrame); ok {
+		return f.event
+	}
+	return frame
+}
+
+// outbox - Bounded, non-blocking queue of frames waiting to be written to a
+// client. Pushing never blocks the pool workers; once full the client's
+// policy decides which event is lost and the loss is reported to the client
+// before its next frame. Control frames (acks, errors and replies) answer the
+// client's own requests and are never dropped, so they may exceed capacity,
+// up to twice capacity of them before the client is disconnected. Durable
+// events are never dropped either; one arriving at a full outbox disconnects
+// the client, which resumes from its cursor when it reconnects.
+type outbox struct {
+	frames   []interface{}
+	capacity int
+	policy   BackpressurePolicy
+	missed   int
+	controls int
+	ready    chan struct{}
+	drained  chan struct{}
+	closed   bool
+	lock     sync.Mutex
+}
+
+func newOutbox(capacity int, policy BackpressurePolicy) *outbox {
+	return &outbox{
+		frames:   make([]interface{}, 0, capacity),
+		capacity: capacity,
+		policy:   policy,
+		ready:    make(chan struct{}, 1),
+		drained:  make(chan struct{}, 1),
+	}
+}
+
+// push - Queues a frame, returning false when the client must be disconnected
+func (o *outbox) push(frame interface{}) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.closed {
+		return true
+	}
+
+	if _, ok := eventOf(frame); !ok {
+		// A client pipelining requests without reading the answers
+		if o.controls >= 2*o.capacity {
+			slowConsumerEvents.Add("control", 1)
+			return false
+		}
+		o.controls++
+		o.frames = append(o.frames, frame)
+		o.signal()
+		return true
+	}
+
+	if len(o.frames) >= o.capacity {
+		if durableOf(frame) {
+			slowConsumerEvents.Add("durable", 1)
+			return false
+		}
+		slowConsumerEvents.Add(string(o.policy), 1)
+
+		switch o.policy {
+		case Disconnect:
+			return false
+		case DropNewest:
+			o.dropped(frame)
+			return true
+		case Coalesce:
+			if o.coalesce(frame) {
+				return true
+			}
+			if !o.dropOldest() {
+				o.dropped(frame)
+				return true
+			}
+		default:
+			if !o.dropOldest() {
+				o.dropped(frame)
+				return true
+			}
+		}
+	}
+
+	o.frames = append(o.frames, frame)
+	o.signal()
+	return true
+}
+
+// coalesce - Replaces a pending event of the same type and subject in place,
+// leaving durable events be
+func (o *outbox) coalesce(frame interface{}) bool {
+	event, ok := eventOf(frame)
+	if !ok {
+		return false
+	}
+	for i, pending := range o.frames {
+		if durableOf(pending) {
+			continue
+		}
+		if p, ok := eventOf(pending); ok && p.Type == event.Type && p.Subject == event.Subject {
+			o.frames[i] = frame
+			o.missed++
+			return true
+		}
+	}
+	return false
+}
+
+// dropOldest - Evicts the oldest pending event other than a durable one,
+// returning false when there is none
+func (o *outbox) dropOldest() bool {
+	for i, pending := range o.frames {
+		if _, ok := eventOf(pending); ok && !durableOf(pending) {
+			o.dropped(pending)
+			o.frames = append(o.frames[:i], o.frames[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (o *outbox) dropped(frame interface{}) {
+	if _, ok := eventOf(frame); ok {
+		o.missed++
+	}
+}
+
+func (o *outbox) signal() {
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+}
+
+// drain - Takes every pending frame along with the count of events lost since
+// the last drain
+func (o *outbox) drain() ([]interface{}, int) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	frames, missed := o.frames[:0], o.missed
+	for _, frame := range o.frames {
+		if event, ok := eventOf(frame); ok && core.DiscardExpired(event, "outbox") {
+			continue
+		}
+		frames = append(frames, frame)
+	}
+	o.frames = make([]interface{}, 0, o.capacity)
+	o.missed = 0
+	o.controls = 0
+	select {
+	case o.drained <- struct{}{}:
+	default:
+	}
+	return frames, missed
+}
+
+// waitRoom - Blocks until a frame can be queued without triggering the
+// backpressure policy, returning false if the outbox closes or timeout passes
+func (o *outbox) waitRoom(timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		o.lock.Lock()
+		closed, full := o.closed, len(o.frames) >= o.capacity
+		o.lock.Unlock()
+		if closed {
+			return false
+		}
+		if !full {
+			return true
+		}
+		select {
+		case <-o.drained:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// pending - Number of frames waiting to be written
+func (o *outbox) pending() int {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return len(o.frames)
+}
+
+// close - Discards pending frames and wakes the writer so it can exit.
+// Returns the consumer group events that were still pending.
+func (o *outbox) close() []eventFrame {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.closed {
+		return nil
+	}
+
+	var unsent []eventFrame
+	for _, frame := range o.frames {
+		if f, ok := frame.(eventFrame); ok && f.group != "" {
+			unsent = append(unsent, f)
+		}
+	}
+	o.closed = true
+	o.frames = nil
+	close(o.ready)
+	select {
+	case o.drained <- struct{}{}:
+	default:
+	}
+	return unsent
+}
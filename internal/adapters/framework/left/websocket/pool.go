@@ -1,43 +1,111 @@
 package websocket
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+	"github.com/josh-tracey/eventual-agent/internal/ports"
 	"github.com/josh-tracey/scribe"
 )
 
 var (
 	timer = time.NewTicker(120 * time.Second)
+
+	// Pool workers, each owning the publishes of one partition, WS_POOL_WORKERS
+	PoolWorkers = getEnvInt("WS_POOL_WORKERS", 32)
 )
 
+func init() {
+	if PoolWorkers <= 0 {
+		panic(fmt.Sprintf("Invalid WS_POOL_WORKERS: %d, must be positive", PoolWorkers))
+	}
+}
+
 // Pool - Shared worker pool resources
 type Pool struct {
 	Subscribe      chan core.SubscribeRequest[*Client]
 	Unsubscribe    chan core.SubscribeRequest[*Client]
 	UnsubscribeAll chan core.SubscribeRequest[*Client]
-	Publish        chan core.PublishRequest[*Client]
+	partitions     [][core.PriorityLevels]chan core.PublishRequest[*Client]
+	Direct         chan core.DirectRequest[*Client]
 	core           *core.Adapter
 	clientsMap     *sync.Map
 	Logging        *scribe.Logger
 	cLock          *sync.RWMutex
 	grpcEventQueue chan *core.CloudEvent
+	publishChannel chan *core.PeerEvent
+	inboxChannel   chan *core.PeerEvent
+	verifier       ports.TokenVerifier
+	origins        *OriginPolicy
+	history        ports.MessageQueuePort
+	inboxes        *inboxes
+	durables       *core.DurableRegistry
+	scheduler      *core.Scheduler
+	schemas        *core.SchemaRegistry
 }
 
 // NewPool - Creates new instance of Pool
-func NewPool(c *core.Adapter, grpcEventQueue chan *core.CloudEvent) *Pool {
+func NewPool(c *core.Adapter, grpcEventQueue chan *core.CloudEvent, publishChannel chan *core.PeerEvent, inboxChannel chan *core.PeerEvent, verifier ports.TokenVerifier, origins *OriginPolicy, history ports.MessageQueuePort, durables *core.DurableRegistry, scheduler *core.Scheduler, schemas *core.SchemaRegistry) *Pool {
 	return &Pool{
 		Subscribe:      make(chan core.SubscribeRequest[*Client], 4),
 		Unsubscribe:    make(chan core.SubscribeRequest[*Client], 4),
 		UnsubscribeAll: make(chan core.SubscribeRequest[*Client], 4),
-		Publish:        make(chan core.PublishRequest[*Client], 4),
+		partitions:     newPartitions(PoolWorkers),
+		Direct:         make(chan core.DirectRequest[*Client], 4),
 		core:           c,
 		clientsMap:     &sync.Map{},
 		Logging:        c.GetLogger(),
 		cLock:          &sync.RWMutex{},
 		grpcEventQueue: grpcEventQueue,
+		publishChannel: publishChannel,
+		inboxChannel:   inboxChannel,
+		verifier:       verifier,
+		origins:        origins,
+		history:        history,
+		inboxes:        newInboxes(),
+		durables:       durables,
+		scheduler:      scheduler,
+		schemas:        schemas,
+	}
+}
+
+func newPartitions(n int) [][core.PriorityLevels]chan core.PublishRequest[*Client] {
+	partitions := make([][core.PriorityLevels]chan core.PublishRequest[*Client], n)
+	for i := range partitions {
+		for priority := range partitions[i] {
+			partitions[i][priority] = make(chan core.PublishRequest[*Client], 4)
+		}
 	}
+	return partitions
+}
+
+// Publish - Queues a publish on the worker owning its partition key, in the
+// lane for its priority, so events sharing a key and priority are routed one
+// at a time in the order they arrive
+func (p *Pool) Publish(r core.PublishRequest[*Client]) {
+	partition := core.Partition(core.PartitionKey(r.Event), len(p.partitions))
+	p.partitions[partition][core.PriorityOf(r.Event)] <- r
+}
+
+// publishScheduled - Publishes a scheduled event that has come due, as if its
+// publisher had just sent it
+func (p *Pool) publishScheduled(e core.ScheduledEvent) {
+	p.Logging.Trace("websocket::Pool.publishScheduled => Publishing scheduled event %s for %s", e.Event.ID, e.Subject)
+	event, err := core.StampExpiry(e.Event, time.Now())
+	if err != nil || core.DiscardExpired(event, "scheduler") {
+		return
+	}
+	e.Event = event
+	p.Publish(core.PublishRequest[*Client]{
+		PublishEvent: core.PublishEvent{
+			Type:    "publish",
+			Channel: e.Channel,
+			Retain:  e.Retain,
+			Event:   e.Event,
+		},
+	})
 }
 
 func (p *Pool) getClient(refId string) *Client {
@@ -62,8 +130,9 @@ func (p *Pool) Cleaner() {
 			p.Logging.Trace("websocket::Pool.Cleaner => Cleaning up clients")
 			p.clientsMap.Range(func(id, client interface{}) bool {
 				if client.(*Client).closed {
-					p.Logging.Trace("websocket::Pool.Cleaner => Removing client %s", client.(*Client).RefID)
-					p.removeClientRefId(client.(*Client).RefID)
+					p.Logging.Trace("websocket::Pool.Cleaner => Removing client %s", client.(*Client).ID)
+					p.unsubscribe(client.(*Client), client.(*Client).channels())
+					p.removeClientRefId(id.(string))
 				}
 				return true
 			})
@@ -80,73 +149,381 @@ func (p *Pool) removeClientRefId(refId string) {
 	p.clientsMap.Delete(refId)
 }
 
-// Start - Go Routine runs worker with shared Pool resources.
-func (p *Pool) Start() {
+// subscribe - Subscribes a client to channels, optionally as a member of a
+// consumer group, reusing existing subscriptions. Returns the ref ID of each
+// channel's subscription.
+func (p *Pool) subscribe(c *Client, channels []string, group string) map[string]string {
+	c.sLock.Lock()
+	defer c.sLock.Unlock()
+
+	refs := make(map[string]string, len(channels))
+	for _, channel := range channels {
+		refID, ok := c.ref(channel)
+		if !ok {
+			refID = p.core.AddGroupPeer(c.ID, channel, group, true)
+			p.addClient(refID, c)
+			c.addRef(channel, refID)
+		}
+		refs[channel] = refID
+	}
+	return refs
+}
+
+func (p *Pool) unsubscribe(c *Client, channels []string) {
+	c.sLock.Lock()
+	defer c.sLock.Unlock()
+
+	for _, channel := range channels {
+		if refID, ok := c.removeRef(channel); ok {
+			p.core.Unsubscribe(c.ID, channel, refID)
+			p.removeClientRefId(refID)
+			p.leave(c, channel)
+		}
+	}
+}
+
+// join - Adds a client to the presence channels among channels and announces
+// it to the other members
+func (p *Pool) join(c *Client, channels []string, meta map[string]interface{}) {
+	for _, channel := range channels {
+		if !core.IsPresenceChannel(channel) {
+			continue
+		}
+		member := core.Member{ID: c.ID, Subject: c.Identity().Subject, Meta: meta}
+		if p.core.Join(channel, member) {
+			p.announce(c, channel, core.PresenceJoin, member)
+		}
+	}
+}
+
+// leave - Removes a client from a presence channel and announces it to the
+// remaining members
+func (p *Pool) leave(c *Client, channel string) {
+	if !core.IsPresenceChannel(channel) {
+		return
+	}
+	if member, ok := p.core.Leave(channel, c.ID); ok {
+		p.announce(c, channel, core.PresenceLeave, member)
+	}
+}
+
+// announce - Sends a presence notification to every connected subscriber of
+// channel other than the client it is about
+func (p *Pool) announce(c *Client, channel string, kind string, member core.Member) {
+	msg := core.PresenceMessage{Type: kind, Channel: channel, Member: member}
+	for _, s := range p.core.Subscribers(channel) {
+		other := p.getClient(s.RefID)
+		if other == nil || other == c || other.closed {
+			continue
+		}
+		other.reply(msg)
+	}
+}
+
+// route - Delivers a frame to clients subscribed to channel or to "global",
+// at most once per client
+func (p *Pool) route(channel string, frame interface{}) {
+	typed := p.core.Subscribers(channel)
+	global := p.core.Subscribers("global")
+
+	var delivered map[*Client]bool
+	if len(typed) > 0 && len(global) > 0 {
+		delivered = make(map[*Client]bool, len(typed))
+	}
+
+	for channel, subscribers := range map[string][]core.Subscriber{channel: typed, "global": global} {
+		solo, groups := core.SplitGroups(subscribers)
+		for group, members := range groups {
+			p.deliverToGroup(channel, group, members, frame)
+		}
+		for _, s := range solo {
+			c := p.connected(channel, s)
+			if c == nil {
+				continue
+			}
+			if delivered != nil {
+				if delivered[c] {
+					continue
+				}
+				delivered[c] = true
+			}
+			p.Logging.Trace("websocket::Pool.route => Publishing event to client %v, subscribed to channel %v", s.RefID, channel)
+			if event, ok := frame.(core.CloudEvent); ok && s.Durable != "" {
+				c.deliverDurable(eventFrame{channel: channel, durable: s.Durable, event: event})
+				continue
+			}
+			c.send(frame)
+		}
+	}
+}
+
+// connected - Client behind a subscription, removing the subscription when
+// the client has gone away. A closed client is unsubscribed in full so it
+// also leaves the channel's presence, which the Cleaner can no longer do once
+// the ref is gone.
+func (p *Pool) connected(channel string, s core.Subscriber) *Client {
+	c := p.getClient(s.RefID)
+	if c != nil && !c.closed {
+		return c
+	}
+
+	p.Logging.Trace("websocket::Pool.route => Client %s is not connected, removing from subscription", s.RefID)
+	if c != nil {
+		p.unsubscribe(c, []string{channel})
+		return nil
+	}
+	p.core.Unsubscribe(s.Client, channel, s.RefID)
+	p.removeClientRefId(s.RefID)
+	return nil
+}
+
+// deliverToGroup - Sends a frame to one connected member of a consumer group
+func (p *Pool) deliverToGroup(channel string, group string, members []core.Subscriber, frame interface{}) {
+	clients := make([]*Client, 0, len(members))
+	for _, s := range members {
+		if c := p.connected(channel, s); c != nil {
+			clients = append(clients, c)
+		}
+	}
+
+	i := p.core.PickMember(channel, group, len(clients), func(i int) int {
+		return clients[i].Send.pending()
+	})
+	if i < 0 {
+		p.Logging.Trace("websocket::Pool.deliverToGroup => No connected member in group %s on channel %s", group, channel)
+		return
+	}
+	if event, ok := frame.(core.CloudEvent); ok {
+		frame = eventFrame{channel: channel, group: group, event: event}
+	}
+	clients[i].send(frame)
+}
+
+// redistribute - Hands consumer group events left unsent by a closed client
+// to the remaining members of their group
+func (p *Pool) redistribute(frames []eventFrame) {
+	defer func() {
+		if err := recover(); err != nil {
+			p.Logging.Error("websocket::Pool.redistribute => unhandled exception: %+v", err)
+		}
+	}()
+
+	for _, f := range frames {
+		_, groups := core.SplitGroups(p.core.Subscribers(f.channel))
+		p.deliverToGroup(f.channel, f.group, groups[f.group], f.event)
+	}
+}
+
+// request - Routes a request to the channel's subscribers and peer servers,
+// answering the requester with the first reply or a timeout error
+func (p *Pool) request(r core.PublishRequest[*Client]) {
+	requester, id, channel := r.Client, r.ID, r.Event.Type
+	replyTo := p.core.ExpectReply(channel, time.Duration(r.Timeout)*time.Millisecond, func(event core.CloudEvent, ok bool) {
+		if !ok {
+			requester.reply(core.NewErrorMessage(id, core.ErrCodeTimeout, "no reply to request on "+channel))
+			return
+		}
+		requester.reply(core.ReplyMessage{Type: "reply", ID: id, Event: event})
+	})
+
+	event := r.Event
+	extensions := make(map[string]string, len(event.Extensions)+1)
+	for name, value := range event.Extensions {
+		extensions[name] = value
+	}
+	extensions[core.ExtReplyTo] = replyTo
+	event.Extensions = extensions
+
+	p.grpcEventQueue <- &event
+
+	p.route(channel, core.RequestMessage{Type: "request", Channel: channel, ReplyTo: replyTo, Event: event})
+}
+
+// Start - Go Routine runs worker with shared Pool resources, handling the
+// publishes of one partition. Waiting publishes are served by priority
+// weight, with subscription changes polled between them.
+func (p *Pool) Start(partition int) {
 
 	defer func() {
 		if err := recover(); err != nil {
 			p.Logging.Error("websocket::Pool.Start => unhandled exception: %+v", err)
 		}
 		p.Logging.Warn("Worker stopped")
-		p.Start()
+		p.Start(partition)
 	}()
 
+	lanes := p.partitions[partition]
+	var weighted core.WeightedLanes
+
 	for {
-		select {
+		priority := weighted.Next(func(priority core.Priority) bool {
+			return len(lanes[priority]) > 0
+		})
+		if priority >= 0 {
+			p.publish(<-lanes[priority])
 
-		case r := <-p.Publish:
-			start := time.Now()
-			p.Logging.Trace("websocket::Pool.Start.Publish => Received publish event for channel '%s'", r.Event.Type)
-
-			go func() {
-				p.grpcEventQueue <- &r.Event
-			}()
-
-			for _, sub := range p.core.GetPeerClients() {
-				for _, channel := range sub.GetChannels() {
-					if *channel == r.Event.Type || *channel == "global" {
-						subscription := p.core.GetSub(*channel)
-						if subscription != nil {
-							p.Logging.Trace("websocket::Pool.Start.Publish => Sending event to client %v", sub.ID)
-							client := subscription.GetClient(*channel)
-							for i, c := range client {
-								c := p.getClient(*c)
-								if c != nil && !c.closed {
-									p.Logging.Trace("websocket::Pool.Start.Publish => Publishing event to client %v, subscribed to channel %v", *client[i], channel)
-									c.Send <- r.Event
-								} else {
-									p.Logging.Trace("websocket::Pool.Start.Publish => Client %s is not connected, removing from subscription", *client[i])
-									p.removeClientRefId(*client[i])
-								}
-							}
-						}
-					}
-				}
+			select {
+			case r := <-p.Direct:
+				p.direct(r)
+			case e := <-p.inboxChannel:
+				p.forwarded(e)
+			case r := <-p.Subscribe:
+				p.subscribeRequest(r)
+			case r := <-p.Unsubscribe:
+				p.unsubscribeRequest(r)
+			case r := <-p.UnsubscribeAll:
+				p.unsubscribeAll(r)
+			default:
 			}
+			continue
+		}
 
-			p.Logging.Duration(start, "Pool::Start::Publish")
-
+		select {
+		case r := <-lanes[core.PriorityHigh]:
+			p.publish(r)
+		case r := <-lanes[core.PriorityNormal]:
+			p.publish(r)
+		case r := <-lanes[core.PriorityLow]:
+			p.publish(r)
+		case r := <-p.Direct:
+			p.direct(r)
+		case e := <-p.inboxChannel:
+			p.forwarded(e)
 		case r := <-p.Subscribe:
-			p.Logging.Trace("websocket::Pool.Start.Subscribe => Received subscribe event for channels '%s'", r.Channels)
-			for _, channel := range r.Channels {
-				refID := p.core.AddPeer(r.Client.ID, channel, true)
-				p.addClient(refID, r.Client)
-				p.Logging.Trace("websocket::Pool.Start.Subscribe => Added client %v to subscriptions", p.core.GetPeerClients())
-			}
-
+			p.subscribeRequest(r)
 		case r := <-p.Unsubscribe:
-			p.Logging.Trace("websocket::Pool.Start.Unsubscribe => Received unsubscribe event for channels '%s'", r.Channels)
-			p.removeClientRefId(r.Client.RefID)
-			for _, channel := range r.Channels {
-				p.core.RemovePeer(channel, true)
-			}
-
+			p.unsubscribeRequest(r)
 		case r := <-p.UnsubscribeAll:
-			p.Logging.Trace("websocket::Pool.Start.UnsubscribeAll => Received UnsubscribeAll for %s", r.Client.ID)
-			p.removeClientRefId(r.Client.RefID)
-			for _, channel := range r.Channels {
-				p.core.RemovePeer(channel, true)
-			}
+			p.unsubscribeAll(r)
 		}
 	}
 }
+
+func (p *Pool) publish(r core.PublishRequest[*Client]) {
+	if core.DiscardExpired(r.Event, "publish") {
+		if r.Client != nil {
+			r.Client.reply(core.AckMessage{Type: core.AckPublished, ID: r.ID, Channel: r.Event.Type})
+		}
+		return
+	}
+	if r.Type == "request" {
+		p.Logging.Trace("websocket::Pool.Start.Publish => Received request for channel '%s'", r.Event.Type)
+		p.request(r)
+		return
+	}
+
+	start := time.Now()
+	p.Logging.Trace("websocket::Pool.Start.Publish => Received publish event for channel '%s'", r.Event.Type)
+
+	events := p.core.Route(r.Event)
+	for i := range events {
+		event := &events[i]
+
+		// Sent inline so peer servers see a partition's events in order
+		p.grpcEventQueue <- event
+
+		if r.Retain {
+			p.core.Retain(event.Type, *event)
+		}
+		p.history.Enqueue(event.Type, *event)
+
+		p.route(event.Type, *event)
+	}
+
+	if r.Client != nil {
+		r.Client.reply(core.AckMessage{
+			Type:    core.AckPublished,
+			ID:      r.ID,
+			Channel: r.Event.Type,
+		})
+	}
+
+	p.Logging.Duration(start, "Pool::Start::Publish")
+}
+
+func (p *Pool) direct(r core.DirectRequest[*Client]) {
+	p.Logging.Trace("websocket::Pool.Start.Direct => Received direct event for user '%s' connection '%s'", r.User, r.Connection)
+	msg := core.DirectMessage{
+		Type:       "direct",
+		From:       r.Client.Identity().Subject,
+		Connection: r.Client.ID,
+		Event:      r.Event,
+	}
+
+	delivered := 0
+	for _, c := range p.recipients(r.DirectEvent) {
+		if c.closed {
+			continue
+		}
+		c.send(msg)
+		delivered++
+	}
+	if r.User != "" {
+		for _, peerEvent := range p.core.DirectPeerEvents(r.User, msg) {
+			p.publishChannel <- peerEvent
+			delivered++
+		}
+	}
+
+	if delivered == 0 {
+		r.Client.reply(core.NewErrorMessage(r.ID, core.ErrCodeNotConnected, "recipient is not connected"))
+		return
+	}
+	r.Client.reply(core.AckMessage{Type: core.AckDelivered, ID: r.ID})
+}
+
+// forwarded - Delivers a direct message forwarded by another agent to the
+// recipient's connections
+func (p *Pool) forwarded(e *core.PeerEvent) {
+	user, _ := core.InboxUser(e.Channel)
+	p.Logging.Trace("websocket::Pool.Start.Forwarded => Received direct event from '%s' for user '%s'", e.From, user)
+	msg := core.DirectMessage{
+		Type:       "direct",
+		From:       e.From,
+		Connection: e.Connection,
+		Event:      e.Event,
+	}
+	for _, c := range p.inboxes.user(user) {
+		if c.closed {
+			continue
+		}
+		c.send(msg)
+	}
+}
+
+func (p *Pool) subscribeRequest(r core.SubscribeRequest[*Client]) {
+	p.Logging.Trace("websocket::Pool.Start.Subscribe => Received subscribe event for channels '%s'", r.Channels)
+	if r.Durable != "" {
+		p.resume(r.Client, r)
+		return
+	}
+	refs := p.subscribe(r.Client, r.Channels, r.Group)
+	r.Client.reply(core.AckMessage{
+		Type:     core.AckSubscribed,
+		ID:       r.ID,
+		Channels: r.Channels,
+		Refs:     refs,
+	})
+	p.join(r.Client, r.Channels, r.Meta)
+	for _, channel := range r.Channels {
+		for _, event := range p.core.Retained(channel) {
+			r.Client.send(event)
+		}
+	}
+}
+
+func (p *Pool) unsubscribeRequest(r core.SubscribeRequest[*Client]) {
+	p.Logging.Trace("websocket::Pool.Start.Unsubscribe => Received unsubscribe event for channels '%s'", r.Channels)
+	p.unsubscribe(r.Client, r.Channels)
+	r.Client.reply(core.AckMessage{
+		Type:     core.AckUnsubscribed,
+		ID:       r.ID,
+		Channels: r.Channels,
+	})
+}
+
+func (p *Pool) unsubscribeAll(r core.SubscribeRequest[*Client]) {
+	p.Logging.Trace("websocket::Pool.Start.UnsubscribeAll => Received UnsubscribeAll for %s", r.Client.ID)
+	p.unsubscribe(r.Client, r.Client.channels())
+	p.core.RemovePeer(r.Client.ID, true)
+}
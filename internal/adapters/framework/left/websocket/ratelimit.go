@@ -0,0 +1,51 @@
+package websocket
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+var (
+	// Rate limit violations tolerated per ViolationWindow before the
+	// connection is closed, 0 never closes.
+	MaxViolations   = getEnvInt("RATELIMIT_MAX_VIOLATIONS", 10)
+	ViolationWindow = time.Minute
+
+	errRateLimited = errors.New("rate limit exceeded")
+)
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(getEnv(key, strconv.Itoa(fallback)))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// rateLimited - Rejects an over-limit command and closes the connection once
+// it has been rejected too often. Returns an error when the client must be
+// disconnected.
+func (c *Client) rateLimited(id string) error {
+	c.reply(core.NewErrorMessage(id, core.ErrCodeRateLimited, errRateLimited.Error()))
+
+	now := time.Now()
+	if now.Sub(c.violationsSince) > ViolationWindow {
+		c.violations = 0
+		c.violationsSince = now
+	}
+	c.violations++
+
+	if MaxViolations > 0 && c.violations >= MaxViolations {
+		c.Pool.Logging.Warn("websocket::Client.rateLimited => closing %s after %d violations", c.ID, c.violations)
+		closeMessage := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, errRateLimited.Error())
+		if err := c.Conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(WriteWait)); err != nil {
+			c.Pool.Logging.Trace("failed to write close message: %+v", err)
+		}
+		return errRateLimited
+	}
+	return nil
+}
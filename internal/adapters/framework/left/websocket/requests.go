@@ -1,6 +1,9 @@
 package websocket
 
 import (
+	"encoding/json"
+	"fmt"
+
 	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
 )
 
@@ -21,45 +24,154 @@ func NewSubscribeMessage(m map[string]interface{}) *core.SubscribeMessage {
 	}
 }
 
-func (c *Client) NewPublishRequest(m map[string]interface{}) *core.PublishRequest[*Client] {
+// NewPublishRequest - Reads a publish or request frame, along with the event
+// attributes found to be of the wrong type
+func (c *Client) NewPublishRequest(m map[string]interface{}) (*core.PublishRequest[*Client], core.ValidationError) {
 	defer func() {
 		if r := recover(); r != nil {
 			c.Pool.Logging.Error("websocket::Client.NewPublishRequest => %s", r)
 		}
 	}()
 
-	event := m["event"].(map[string]interface{})
+	retain, _ := m["retain"].(bool)
+	timeout, _ := m["timeout"].(float64)
+	event, malformed := newCloudEvent(m["event"].(map[string]interface{}))
 
-	meta, ok := event["meta"].(string)
+	return &core.PublishRequest[*Client]{
+		PublishEvent: core.PublishEvent{
+			Type:    m["type"].(string),
+			ID:      correlationID(m),
+			Channel: m["channel"].(string),
+			Retain:  retain,
+			Timeout: int(timeout),
+			Event:   event,
+		},
+		Client: c,
+	}, malformed
+}
 
-	if !ok {
-		meta = ""
+// validEvent - Applies core.ValidateEvent to an incoming event, replying with
+// the violations when it is rejected. Attributes of the wrong type are
+// reported on their own, as the event read from them is meaningless.
+func (c *Client) validEvent(id string, event *core.CloudEvent, malformed core.ValidationError) bool {
+	if len(malformed) > 0 {
+		c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidEvent, malformed.Error()))
+		return false
 	}
+	valid, err := core.ValidateEvent(*event, core.Validation)
+	if err != nil {
+		c.reply(core.NewErrorMessage(id, core.ErrCodeInvalidEvent, err.Error()))
+		return false
+	}
+	*event = valid
+	return true
+}
 
-	subject, ok := event["subject"].(string)
+// NewDirectRequest - Reads a direct frame, along with the event attributes
+// found to be of the wrong type
+func (c *Client) NewDirectRequest(m map[string]interface{}) (*core.DirectRequest[*Client], core.ValidationError) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.Pool.Logging.Error("websocket::Client.NewDirectRequest => %s", r)
+		}
+	}()
 
-	if !ok {
-		subject = "*"
-	}
+	user, _ := m["user"].(string)
+	connection, _ := m["connection"].(string)
+	event, malformed := newCloudEvent(m["event"].(map[string]interface{}))
 
-	return &core.PublishRequest[*Client]{
-		PublishEvent: core.PublishEvent{
-			Type:    m["type"].(string),
-			Channel: m["channel"].(string),
-			Event: core.CloudEvent{
-				ID:              string(event["id"].(string)),
-				Source:          string(event["source"].(string)),
-				Type:            string(event["type"].(string)),
-				Subject:         string(subject),
-				Data:            string(event["data"].(string)),
-				SpecVersion:     string(event["specversion"].(string)),
-				DataContentType: "application/json",
-				Time:            string(event["time"].(string)),
-				Meta:            string(meta),
-			},
+	return &core.DirectRequest[*Client]{
+		DirectEvent: core.DirectEvent{
+			Type:       m["type"].(string),
+			ID:         correlationID(m),
+			User:       user,
+			Connection: connection,
+			Event:      event,
 		},
 		Client: c,
+	}, malformed
+}
+
+// NewReplyEvent - Reads the event answering a request, along with its
+// attributes found to be of the wrong type
+func (c *Client) NewReplyEvent(m map[string]interface{}) (*core.CloudEvent, core.ValidationError) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.Pool.Logging.Error("websocket::Client.NewReplyEvent => %s", r)
+		}
+	}()
+
+	event, malformed := newCloudEvent(m["event"].(map[string]interface{}))
+	return &event, malformed
+}
+
+// newCloudEvent - Reads the event of a publish or direct frame. Missing
+// attributes are left empty for core.ValidateEvent to report, or take their
+// default; attributes of the wrong type are returned as violations. Data
+// other than a string is carried as its JSON encoding.
+func newCloudEvent(event map[string]interface{}) (core.CloudEvent, core.ValidationError) {
+	var malformed core.ValidationError
+	attribute := func(name string, fallback string) string {
+		value, ok := event[name]
+		if !ok || value == nil {
+			return fallback
+		}
+		s, ok := value.(string)
+		if !ok {
+			malformed = append(malformed, core.Violation{Attribute: name, Reason: "must be a string"})
+		}
+		return s
 	}
+
+	id := attribute("id", "")
+	source := attribute("source", "")
+	specVersion := attribute("specversion", "")
+	eventType := attribute("type", "")
+	dataContentType := attribute("datacontenttype", "application/json")
+	dataSchema := attribute("dataschema", "")
+	subject := attribute("subject", "*")
+	eventTime := attribute("time", "")
+	meta := attribute("meta", "")
+
+	var data string
+	switch value := event["data"].(type) {
+	case nil:
+	case string:
+		data = value
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			malformed = append(malformed, core.Violation{Attribute: "data", Reason: "must be a string or JSON value"})
+		}
+		data = string(encoded)
+	}
+
+	var extensions map[string]string
+	switch values := event["extensions"].(type) {
+	case nil:
+	case map[string]interface{}:
+		extensions = make(map[string]string, len(values))
+		for name, value := range values {
+			extensions[name] = fmt.Sprint(value)
+		}
+	default:
+		malformed = append(malformed, core.Violation{Attribute: "extensions", Reason: "must be an object"})
+	}
+
+	cloudEvent := core.CloudEvent{
+		ID:              id,
+		Source:          source,
+		Type:            eventType,
+		Subject:         string(subject),
+		Data:            data,
+		SpecVersion:     specVersion,
+		DataContentType: dataContentType,
+		DataSchema:      dataSchema,
+		Time:            eventTime,
+		Meta:            string(meta),
+		Extensions:      extensions,
+	}
+	return cloudEvent, malformed
 }
 
 func (c *Client) NewSubscribeRequest(m map[string]interface{}) *core.SubscribeRequest[*Client] {
@@ -75,11 +187,24 @@ func (c *Client) NewSubscribeRequest(m map[string]interface{}) *core.SubscribeRe
 		c.Pool.Logging.Debug("channels is not a string slice: %+v", m["channels"])
 		panic("channels is not a string slice")
 	}
+	meta, _ := m["meta"].(map[string]interface{})
+	group, _ := m["group"].(string)
+	durable, _ := m["durable"].(string)
 	return &core.SubscribeRequest[*Client]{
 		SubscribeMessage: core.SubscribeMessage{
 			Type:     m["type"].(string),
+			ID:       correlationID(m),
 			Channels: core.ConvertToStringSlice(channels),
+			Group:    group,
+			Durable:  durable,
+			Meta:     meta,
 		},
 		Client: c,
 	}
 }
+
+// correlationID - Optional client supplied ID echoed back on replies
+func correlationID(m map[string]interface{}) string {
+	id, _ := m["id"].(string)
+	return id
+}
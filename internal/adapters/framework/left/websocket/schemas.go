@@ -0,0 +1,57 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+// serveSchemas - GET /schemas listing the registered schemas of the types the
+// caller may publish or subscribe to, or GET /schemas?type=<type> returning
+// the type's default schema document, or the one with $id dataschema
+func serveSchemas(pool *Pool, w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			pool.Logging.Error("websocket::Pool.serveSchemas => %s", r)
+		}
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, _ := tokenFromRequest(r)
+	claims, err := pool.authenticate(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	eventType := r.URL.Query().Get("type")
+	if eventType == "" {
+		schemas := []core.SchemaDocument{}
+		for _, schema := range pool.schemas.List() {
+			if claims.CanPublish(schema.Type) || claims.CanSubscribe(schema.Type) {
+				schemas = append(schemas, schema)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(core.SchemasMessage{Type: "schemas", Schemas: schemas})
+		return
+	}
+
+	if !claims.CanPublish(eventType) && !claims.CanSubscribe(eventType) {
+		http.Error(w, "not authorized for "+eventType, http.StatusForbidden)
+		return
+	}
+	schema, ok := pool.schemas.Get(eventType, r.URL.Query().Get("dataschema"))
+	if !ok {
+		http.Error(w, "no schema registered", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(schema.Schema)
+}
@@ -10,13 +10,13 @@ import (
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:    1024,
 	WriteBufferSize:   1024,
-	CheckOrigin:       func(r *http.Request) bool { return true }, // TODO: Implement cross origin header check...
+	CheckOrigin:       func(r *http.Request) bool { return true }, // Checked against the OriginPolicy in serveWs before upgrading.
 	EnableCompression: true,                                       // Tries to use compression where possible.
 }
 
-func Upgrade(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+func Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*websocket.Conn, error) {
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -0,0 +1,30 @@
+package filestore
+
+import (
+	"os"
+
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+// File durable subscriptions are kept in, DURABLE_STORE_FILE. Empty keeps
+// them in memory only.
+var CursorsPath = os.Getenv("DURABLE_STORE_FILE")
+
+// CursorStore - CursorStorePort writing durable subscriptions to a JSON file
+type CursorStore struct {
+	path string
+}
+
+func NewCursorStore(path string) *CursorStore {
+	return &CursorStore{path: path}
+}
+
+func (s *CursorStore) Load() ([]core.Durable, error) {
+	var durables []core.Durable
+	err := readJSON(s.path, &durables)
+	return durables, err
+}
+
+func (s *CursorStore) Save(durables []core.Durable) error {
+	return writeJSON(s.path, durables)
+}
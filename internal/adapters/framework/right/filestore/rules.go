@@ -0,0 +1,43 @@
+package filestore
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+// File the routing rules and sinks are declared in, ROUTING_RULES_FILE.
+// Empty routes by channel only.
+var RulesPath = os.Getenv("ROUTING_RULES_FILE")
+
+// RulesStore - RulesStorePort reading the routing table from a JSON file
+type RulesStore struct {
+	path string
+}
+
+func NewRulesStore(path string) *RulesStore {
+	return &RulesStore{path: path}
+}
+
+func (s *RulesStore) Load() (core.RoutingTable, error) {
+	var table core.RoutingTable
+	err := readJSON(s.path, &table)
+	return table, err
+}
+
+// Modified - When the file last changed, zero while it does not exist
+func (s *RulesStore) Modified() (time.Time, error) {
+	if s.path == "" {
+		return time.Time{}, nil
+	}
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
@@ -0,0 +1,41 @@
+package filestore
+
+import (
+	"os"
+
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+// File scheduled events are kept in, SCHEDULE_STORE_FILE. Defaults to a file
+// beside DURABLE_STORE_FILE when that is set, otherwise memory only.
+var SchedulePath = schedulePath()
+
+func schedulePath() string {
+	if path := os.Getenv("SCHEDULE_STORE_FILE"); path != "" {
+		return path
+	}
+	if CursorsPath != "" {
+		return CursorsPath + ".schedule"
+	}
+	return ""
+}
+
+// ScheduleStore - ScheduleStorePort writing pending scheduled events to a
+// JSON file
+type ScheduleStore struct {
+	path string
+}
+
+func NewScheduleStore(path string) *ScheduleStore {
+	return &ScheduleStore{path: path}
+}
+
+func (s *ScheduleStore) Load() ([]core.ScheduledEvent, error) {
+	var events []core.ScheduledEvent
+	err := readJSON(s.path, &events)
+	return events, err
+}
+
+func (s *ScheduleStore) Save(events []core.ScheduledEvent) error {
+	return writeJSON(s.path, events)
+}
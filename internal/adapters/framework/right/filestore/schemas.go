@@ -0,0 +1,66 @@
+package filestore
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+// Directory JSON Schemas are loaded from, SCHEMA_DIR. Empty registers none.
+var SchemaDir = os.Getenv("SCHEMA_DIR")
+
+// SchemaStore - SchemaStorePort reading *.json files from a directory tree.
+// A file's name is the event type it validates, with an optional @version
+// suffix for schemas selected only by their $id, e.g. orders.created.json
+// and orders.created@v2.json.
+type SchemaStore struct {
+	dir string
+}
+
+func NewSchemaStore(dir string) *SchemaStore {
+	return &SchemaStore{dir: dir}
+}
+
+func (s *SchemaStore) Load() ([]core.SchemaDocument, error) {
+	if s.dir == "" {
+		return nil, nil
+	}
+
+	var documents []core.SchemaDocument
+	err := filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var header struct {
+			ID string `json:"$id"`
+		}
+		// Malformed documents are reported when the registry compiles them
+		json.Unmarshal(data, &header)
+
+		file, _ := filepath.Rel(s.dir, path)
+		name := strings.TrimSuffix(entry.Name(), ".json")
+		eventType, _, versioned := strings.Cut(name, "@")
+
+		documents = append(documents, core.SchemaDocument{
+			Type:    eventType,
+			ID:      header.ID,
+			Default: !versioned,
+			File:    file,
+			Schema:  data,
+		})
+		return nil
+	})
+	return documents, err
+}
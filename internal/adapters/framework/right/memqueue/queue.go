@@ -0,0 +1,285 @@
+package memqueue
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+var (
+	ErrEmpty = errors.New("channel has no events")
+
+	// Events kept per channel, HISTORY_SIZE
+	DefaultSize = 1000
+	// Append only log the history is restored from on restart, HISTORY_FILE.
+	// Empty keeps the history in memory only.
+	DefaultPath = os.Getenv("HISTORY_FILE")
+)
+
+func init() {
+	if size, err := strconv.Atoi(os.Getenv("HISTORY_SIZE")); err == nil && size > 0 {
+		DefaultSize = size
+	}
+}
+
+// Failed history file writes, keyed by "append" or "compact", published on
+// /debug/vars
+var historyFileErrors = expvar.NewMap("history_file_errors")
+
+type storedEvent struct {
+	event core.CloudEvent
+	at    time.Time
+}
+
+// logEntry - Line of the history file
+type logEntry struct {
+	Channel string          `json:"channel"`
+	At      time.Time       `json:"at"`
+	Event   core.CloudEvent `json:"event"`
+}
+
+// Queue - In memory MessageQueuePort keeping the newest size events of each
+// channel, optionally backed by an append only file. The file is compacted
+// once it holds twice the entries written by the last compaction.
+type Queue struct {
+	channels  map[string][]storedEvent
+	size      int
+	path      string
+	log       *os.File
+	encoder   *json.Encoder
+	entries   int
+	compactAt int
+	lock      sync.RWMutex
+}
+
+func New(size int) *Queue {
+	return &Queue{
+		channels: make(map[string][]storedEvent),
+		size:     size,
+	}
+}
+
+// Open - Queue restored from the history file at path, which is compacted to
+// the retained events and then appended to. An empty path is the same as New.
+func Open(path string, size int) (*Queue, error) {
+	q := New(size)
+	if path == "" {
+		return q, nil
+	}
+
+	if file, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var entry logEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				continue
+			}
+			q.append(entry.Channel, storedEvent{event: entry.Event, at: entry.At})
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	q.path = path
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// compact - Rewrites the history file with only the retained events and
+// keeps it open for appending. Callers must hold lock once the queue is
+// shared.
+func (q *Queue) compact() error {
+	path := q.path
+	tmp := path + ".compact"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	written := 0
+	for channel, events := range q.channels {
+		for _, stored := range events {
+			if err := encoder.Encode(logEntry{Channel: channel, At: stored.at, Event: stored.event}); err != nil {
+				file.Close()
+				return err
+			}
+			written++
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	log, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if q.log != nil {
+		q.log.Close()
+	}
+	q.log = log
+	q.encoder = json.NewEncoder(log)
+	q.entries = written
+	q.compactAt = 2 * written
+	if q.compactAt < q.size {
+		q.compactAt = q.size
+	}
+	return nil
+}
+
+func (q *Queue) Enqueue(channel string, message core.CloudEvent) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	stored := storedEvent{event: message, at: time.Now()}
+	q.append(channel, stored)
+	if q.encoder == nil {
+		return
+	}
+	if err := q.encoder.Encode(logEntry{Channel: channel, At: stored.at, Event: message}); err != nil {
+		historyFileErrors.Add("append", 1)
+	}
+	if q.entries++; q.entries >= q.compactAt {
+		if err := q.compact(); err != nil {
+			historyFileErrors.Add("compact", 1)
+			// Retried after as many entries again rather than on every append
+			q.compactAt = q.entries * 2
+		}
+	}
+}
+
+// live - Drops the channel's expired events and returns the rest. Callers
+// must hold lock.
+func (q *Queue) live(channel string) []storedEvent {
+	events := q.channels[channel]
+	var kept []storedEvent
+	for i, stored := range events {
+		if core.DiscardExpired(stored.event, "history") {
+			if kept == nil {
+				kept = append(make([]storedEvent, 0, len(events)), events[:i]...)
+			}
+			continue
+		}
+		if kept != nil {
+			kept = append(kept, stored)
+		}
+	}
+	if kept == nil {
+		return events
+	}
+	q.channels[channel] = kept
+	return kept
+}
+
+// append - Adds an event, dropping the channel's oldest beyond size. Callers
+// must hold lock.
+func (q *Queue) append(channel string, stored storedEvent) {
+	events := append(q.channels[channel], stored)
+	if len(events) > q.size {
+		events = events[len(events)-q.size:]
+	}
+	q.channels[channel] = events
+}
+
+func (q *Queue) Dequeue(channel string, consume bool) (core.CloudEvent, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	events := q.channels[channel]
+	if len(events) == 0 {
+		return core.CloudEvent{}, ErrEmpty
+	}
+	if consume {
+		q.channels[channel] = events[1:]
+	}
+	return events[0].event, nil
+}
+
+func (q *Queue) Iter(channel string, consume bool) (chan core.CloudEvent, error) {
+	q.lock.Lock()
+	events := q.channels[channel]
+	if consume {
+		delete(q.channels, channel)
+	}
+	q.lock.Unlock()
+
+	c := make(chan core.CloudEvent)
+	go func() {
+		for _, stored := range events {
+			c <- stored.event
+		}
+		close(c)
+	}()
+	return c, nil
+}
+
+func (q *Queue) History(channel string, query core.HistoryQuery) (core.HistoryPage, error) {
+	query = query.Normalize()
+
+	q.lock.Lock()
+	events := q.live(channel)
+	q.lock.Unlock()
+
+	start := 0
+	if query.After != "" {
+		source, id, err := core.ParseEventCursor(query.After)
+		if err != nil {
+			return core.HistoryPage{}, err
+		}
+		start = -1
+		for i := len(events) - 1; i >= 0; i-- {
+			if events[i].event.ID == id && events[i].event.Source == source {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			return core.HistoryPage{}, core.ErrCursorExpired
+		}
+	}
+
+	var matched []storedEvent
+	for _, stored := range events[start:] {
+		if query.Matches(stored.at) {
+			matched = append(matched, stored)
+		}
+	}
+
+	if query.Last > 0 && len(matched) > query.Last {
+		matched = matched[len(matched)-query.Last:]
+	}
+
+	page := core.HistoryPage{}
+	if len(matched) > query.Limit {
+		matched = matched[:query.Limit]
+		page.Next = core.EventCursor(matched[len(matched)-1].event)
+	}
+	page.Events = make([]core.CloudEvent, len(matched))
+	for i, stored := range matched {
+		page.Events[i] = stored.event
+	}
+	return page, nil
+}
@@ -0,0 +1,40 @@
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+// File - SinkPort appending events to a file as JSON lines
+type File struct {
+	file    *os.File
+	encoder *json.Encoder
+	lock    sync.Mutex
+}
+
+func OpenFile(path string) (*File, error) {
+	if path == "" {
+		return nil, errors.New("file sink needs a target path")
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &File{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (f *File) Send(event core.CloudEvent) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.encoder.Encode(event)
+}
+
+func (f *File) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.file.Close()
+}
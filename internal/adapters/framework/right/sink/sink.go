@@ -0,0 +1,20 @@
+package sink
+
+import (
+	"fmt"
+
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+	"github.com/josh-tracey/eventual-agent/internal/ports"
+)
+
+// Open - Sink adapter for a routing table sink, by its type
+func Open(config core.SinkConfig) (ports.SinkPort, error) {
+	switch config.Type {
+	case "file":
+		return OpenFile(config.Target)
+	case "webhook":
+		return NewWebhook(config.Target)
+	default:
+		return nil, fmt.Errorf("sink %s: unknown type %q", config.Name, config.Type)
+	}
+}
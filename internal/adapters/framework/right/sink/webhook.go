@@ -0,0 +1,58 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+// Longest a webhook sink waits for a response, SINK_WEBHOOK_TIMEOUT
+var WebhookTimeout = 10 * time.Second
+
+func init() {
+	if timeout, err := time.ParseDuration(os.Getenv("SINK_WEBHOOK_TIMEOUT")); err == nil && timeout > 0 {
+		WebhookTimeout = timeout
+	}
+}
+
+// Webhook - SinkPort POSTing each event to a URL in the CloudEvents
+// structured JSON mode
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhook(target string) (*Webhook, error) {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("webhook sink needs an http or https target, got %q", target)
+	}
+	return &Webhook{url: target, client: &http.Client{Timeout: WebhookTimeout}}, nil
+}
+
+func (w *Webhook) Send(event core.CloudEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/cloudevents+json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s answered %s", w.url, resp.Status)
+	}
+	return nil
+}
+
+func (w *Webhook) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
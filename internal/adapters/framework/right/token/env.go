@@ -0,0 +1,85 @@
+package token
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/josh-tracey/eventual-agent/internal/ports"
+	"github.com/josh-tracey/scribe"
+)
+
+var (
+	jwtTokenSecret = os.Getenv("JWT_TOKEN_SECRET")
+	jwksFile       = os.Getenv("JWT_JWKS_FILE")
+	pemDir         = os.Getenv("JWT_PEM_DIR")
+	issuer         = os.Getenv("JWT_ISSUER")
+	audience       = os.Getenv("JWT_AUDIENCE")
+	reloadInterval = os.Getenv("JWT_KEYS_RELOAD_INTERVAL")
+	signingKey     = os.Getenv("JWT_SIGNING_KEY")
+	signingKid     = os.Getenv("JWT_SIGNING_KID")
+
+	// Subject of the tokens this agent signs for its peers, PEER_ID,
+	// defaulting to the hostname so peers limit and address each agent on
+	// its own
+	peerID = os.Getenv("PEER_ID")
+)
+
+func init() {
+	if peerID == "" {
+		hostname, err := os.Hostname()
+		if err != nil || hostname == "" {
+			panic("Invalid PEER_ID: unset and the hostname is unavailable")
+		}
+		peerID = hostname
+	}
+}
+
+// NewVerifierFromEnv - Builds the token verifier selected by the environment.
+// JWT_JWKS_FILE or JWT_PEM_DIR select asymmetric verification with periodic
+// key reloads, otherwise tokens are verified with JWT_TOKEN_SECRET.
+func NewVerifierFromEnv(logger *scribe.Logger) (ports.TokenVerifier, error) {
+	var load KeyLoader
+	switch {
+	case jwksFile != "":
+		load = JWKSFile(jwksFile)
+	case pemDir != "":
+		load = PEMDir(pemDir)
+	default:
+		return NewHMACVerifier(jwtTokenSecret, issuer, audience), nil
+	}
+
+	verifier, err := NewKeySetVerifier(load, issuer, audience, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := 5 * time.Minute
+	if reloadInterval != "" {
+		if interval, err = time.ParseDuration(reloadInterval); err != nil {
+			return nil, err
+		}
+	}
+	go verifier.Watch(interval)
+
+	return verifier, nil
+}
+
+// NewSignerFromEnv - Builds the signer used for outgoing peer calls, matching
+// the verifier peers built from the same environment. With JWT_JWKS_FILE or
+// JWT_PEM_DIR tokens are signed with the JWT_SIGNING_KEY private key under
+// JWT_SIGNING_KID, whose public key must be in the peers' key set.
+func NewSignerFromEnv() (ports.TokenSigner, error) {
+	if jwksFile == "" && pemDir == "" {
+		return NewHMACSigner(jwtTokenSecret, peerID, issuer, audience), nil
+	}
+	if signingKey == "" || signingKid == "" {
+		return nil, errors.New("JWT_SIGNING_KEY and JWT_SIGNING_KID are required to sign peer tokens with JWT_JWKS_FILE or JWT_PEM_DIR")
+	}
+
+	key, err := PEMPrivateKey(signingKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySigner(key, signingKid, peerID, issuer, audience)
+}
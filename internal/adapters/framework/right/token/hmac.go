@@ -0,0 +1,60 @@
+package token
+
+import (
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+// Algorithms accepted from the shared secret
+var hmacMethods = []string{"HS256", "HS384", "HS512"}
+
+// HMACVerifier - Verifies tokens signed with the shared JWT_TOKEN_SECRET
+type HMACVerifier struct {
+	secret   string
+	issuer   string
+	audience string
+}
+
+func NewHMACVerifier(secret string, issuer string, audience string) *HMACVerifier {
+	return &HMACVerifier{
+		secret:   secret,
+		issuer:   issuer,
+		audience: audience,
+	}
+}
+
+func (v *HMACVerifier) Verify(token string) (*core.Claims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.NewParser(jwt.WithValidMethods(hmacMethods)).ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return []byte(v.secret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyClaims(claims, v.issuer, v.audience); err != nil {
+		return nil, err
+	}
+
+	return core.NewClaims(claims), nil
+}
+
+// HMACSigner - Signs outgoing peer tokens with the shared JWT_TOKEN_SECRET
+type HMACSigner struct {
+	secret   string
+	peerID   string
+	issuer   string
+	audience string
+}
+
+func NewHMACSigner(secret string, peerID string, issuer string, audience string) *HMACSigner {
+	return &HMACSigner{
+		secret:   secret,
+		peerID:   peerID,
+		issuer:   issuer,
+		audience: audience,
+	}
+}
+
+func (s *HMACSigner) Sign() (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, peerClaims(s.peerID, s.issuer, s.audience)).SignedString([]byte(s.secret))
+}
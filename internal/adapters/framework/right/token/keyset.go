@@ -0,0 +1,149 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+	"github.com/josh-tracey/scribe"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrUnknownKey   = errors.New("no key for token kid")
+
+	errIssuer   = errors.New("token issuer not accepted")
+	errAudience = errors.New("token audience not accepted")
+
+	// Algorithms accepted from asymmetric keys
+	validMethods = []string{"RS256", "ES256", "ES384", "ES512", "EdDSA"}
+)
+
+// KeyLoader - Loads the current set of public keys indexed by kid
+type KeyLoader func() (map[string]crypto.PublicKey, error)
+
+// KeySetVerifier - Verifies RS256, ES256, ES384, ES512 and EdDSA tokens
+// against a set of public keys. Several kids may be active at once so keys can be rotated by
+// publishing the new key before signing with it.
+type KeySetVerifier struct {
+	load     KeyLoader
+	keys     map[string]crypto.PublicKey
+	issuer   string
+	audience string
+	logger   *scribe.Logger
+	lock     sync.RWMutex
+}
+
+func NewKeySetVerifier(load KeyLoader, issuer string, audience string, logger *scribe.Logger) (*KeySetVerifier, error) {
+	v := &KeySetVerifier{
+		load:     load,
+		issuer:   issuer,
+		audience: audience,
+		logger:   logger,
+	}
+	if err := v.Reload(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Reload - Replaces the active keys with a fresh load, keeping the previous
+// keys if loading fails
+func (v *KeySetVerifier) Reload() error {
+	keys, err := v.load()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.New("no verification keys loaded")
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.keys = keys
+	return nil
+}
+
+// Watch - Periodically reloads keys, picking up rotated kids
+func (v *KeySetVerifier) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := v.Reload(); err != nil {
+			v.logger.Error("token::KeySetVerifier.Watch => failed to reload keys: %s", err)
+		}
+	}
+}
+
+func (v *KeySetVerifier) key(kid string) (crypto.PublicKey, bool) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	key, ok := v.keys[kid]
+	return key, ok
+}
+
+func (v *KeySetVerifier) Verify(token string) (*core.Claims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.NewParser(jwt.WithValidMethods(validMethods)).ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := v.key(kid)
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		if !keyMatchesMethod(key, t.Method) {
+			return nil, fmt.Errorf("key %s cannot verify %s tokens", kid, t.Method.Alg())
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyClaims(claims, v.issuer, v.audience); err != nil {
+		return nil, err
+	}
+
+	return core.NewClaims(claims), nil
+}
+
+func keyMatchesMethod(key crypto.PublicKey, method jwt.SigningMethod) bool {
+	expected := signingMethod(key)
+	return expected != nil && expected == method
+}
+
+// signingMethod - The one algorithm a key signs and verifies with, ECDSA
+// keys by their curve, nil for unsupported keys
+func signingMethod(key crypto.PublicKey) jwt.SigningMethod {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return jwt.SigningMethodES256
+		case 384:
+			return jwt.SigningMethodES384
+		case 521:
+			return jwt.SigningMethodES512
+		}
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA
+	}
+	return nil
+}
+
+func verifyClaims(claims jwt.MapClaims, issuer string, audience string) error {
+	if issuer != "" && !claims.VerifyIssuer(issuer, true) {
+		return errIssuer
+	}
+	if audience != "" && !claims.VerifyAudience(audience, true) {
+		return errAudience
+	}
+	return nil
+}
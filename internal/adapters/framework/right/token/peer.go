@@ -0,0 +1,69 @@
+package token
+
+import (
+	"crypto"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+)
+
+// How long a peer token is accepted for, tokens are signed per call
+const peerTokenLifetime = time.Minute
+
+// peerClaims - Claims of a token for another agent, naming the agent as its
+// subject, marked with the peer role and allowed to publish and subscribe on
+// every channel
+func peerClaims(peerID string, issuer string, audience string) jwt.MapClaims {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":  peerID,
+		"role": core.RolePeer,
+		"iat":  now.Unix(),
+		"exp":  now.Add(peerTokenLifetime).Unix(),
+		"channels": map[string]interface{}{
+			"pub": []string{">"},
+			"sub": []string{">"},
+		},
+	}
+	if issuer != "" {
+		claims["iss"] = issuer
+	}
+	if audience != "" {
+		claims["aud"] = audience
+	}
+	return claims
+}
+
+// KeySigner - Signs outgoing peer tokens with a private key, the token's kid
+// naming the public key peers verify it with
+type KeySigner struct {
+	key      crypto.Signer
+	method   jwt.SigningMethod
+	kid      string
+	peerID   string
+	issuer   string
+	audience string
+}
+
+func NewKeySigner(key crypto.Signer, kid string, peerID string, issuer string, audience string) (*KeySigner, error) {
+	method := signingMethod(key.Public())
+	if method == nil {
+		return nil, fmt.Errorf("unsupported signing key %T", key)
+	}
+	return &KeySigner{
+		key:      key,
+		method:   method,
+		kid:      kid,
+		peerID:   peerID,
+		issuer:   issuer,
+		audience: audience,
+	}, nil
+}
+
+func (s *KeySigner) Sign() (string, error) {
+	token := jwt.NewWithClaims(s.method, peerClaims(s.peerID, s.issuer, s.audience))
+	token.Header["kid"] = s.kid
+	return token.SignedString(s.key)
+}
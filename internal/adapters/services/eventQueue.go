@@ -2,21 +2,41 @@ package services
 
 import (
 	"errors"
-	"sync"
+	"os"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
 	"github.com/josh-tracey/eventual-agent/internal/ports"
 )
 
+var (
+	// Events fanned out to peer servers per flush
+	BatchSize = 64
+	// Longest an event waits for its batch to fill
+	BatchMaxLatency = time.Millisecond
+)
+
+func init() {
+	if size, err := strconv.Atoi(os.Getenv("EVENTQUEUE_BATCH_SIZE")); err == nil && size > 0 {
+		BatchSize = size
+	}
+	if latency, err := time.ParseDuration(os.Getenv("EVENTQUEUE_MAX_LATENCY")); err == nil && latency > 0 {
+		BatchMaxLatency = latency
+	}
+}
+
+// EventQueue - Fans events published by WebSocket clients out to subscribed
+// peer servers. Events are batched and flushed when the batch is full or its
+// oldest event has waited BatchMaxLatency; the flush timer is only armed while
+// a batch is pending so an idle queue never wakes up.
 type EventQueue struct {
 	eventQueueChan chan *core.CloudEvent
 	subsChannel    chan *core.PeerRequest
 	publishChannel chan *core.PeerEvent
-	events         []*core.CloudEvent
-	lock           *sync.RWMutex
+	batch          []*core.CloudEvent
 	subs           *core.Adapter
-	timer          time.Ticker
 }
 
 func NewEventQueue(
@@ -31,73 +51,68 @@ func NewEventQueue(
 	}
 
 	return &EventQueue{
-		events:         []*core.CloudEvent{},
-		lock:           &sync.RWMutex{},
+		batch:          make([]*core.CloudEvent, 0, BatchSize),
 		subs:           value,
 		eventQueueChan: eventQueueChan,
 		subsChannel:    subsChannel,
 		publishChannel: publishChannel,
-		timer:          *time.NewTicker(time.Microsecond * 100),
 	}, nil
 }
 
-func (q *EventQueue) Subscribe(peerServer string, channel string, ephemeral bool) {
-	q.subs.AddPeer(peerServer, channel, ephemeral)
-}
-
-func (eq *EventQueue) AddEvent(event *core.CloudEvent) {
-	eq.lock.Lock()
-	defer eq.lock.Unlock()
-
-	eq.events = append(eq.events, event)
+func (q *EventQueue) Subscribe(peerServer string, channel string, group string, ephemeral bool) {
+	q.subs.AddGroupPeer(peerServer, channel, group, ephemeral)
 }
 
-func (eq *EventQueue) GetEvents() (*[]*core.CloudEvent, bool) {
-	eq.lock.RLock()
-	defer eq.lock.RUnlock()
-	numEvents := len(eq.events)
-
-	if numEvents > 0 {
-		return &eq.events, true
+// flush - Sends the pending batch to the peer servers, one member per
+// consumer group, higher priorities first. Only called from Run, which owns
+// the batch, so nothing can be appended mid flush.
+func (eq *EventQueue) flush() {
+	if len(eq.batch) == 0 {
+		return
 	}
-	return nil, false
-}
-
-func (eq *EventQueue) ClearEvents() {
-	eq.lock.Lock()
-	defer eq.lock.Unlock()
-
-	eq.events = make([]*core.CloudEvent, 0)
-}
 
-func (eq *EventQueue) GetEventCount() int {
-	eq.lock.RLock()
-	defer eq.lock.RUnlock()
+	// Stable so events of one priority keep their order
+	sort.SliceStable(eq.batch, func(i, j int) bool {
+		return core.PriorityOf(*eq.batch[i]) < core.PriorityOf(*eq.batch[j])
+	})
 
-	return len(eq.events)
+	for _, event := range eq.batch {
+		if core.DiscardExpired(*event, "eventqueue") {
+			continue
+		}
+		for _, peerEvent := range eq.subs.PeerEvents(*event) {
+			eq.publishChannel <- peerEvent
+		}
+	}
+	eq.batch = eq.batch[:0]
 }
 
 func (eq *EventQueue) Run() {
+	timer := time.NewTimer(BatchMaxLatency)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	armed := false
 
 	for {
 		select {
 		case peerRequest := <-eq.subsChannel:
-			eq.Subscribe(peerRequest.PeerAddr, peerRequest.Channel, peerRequest.Ephemeral)
+			eq.Subscribe(peerRequest.PeerAddr, peerRequest.Channel, peerRequest.Group, peerRequest.Ephemeral)
 		case event := <-eq.eventQueueChan:
-			eq.AddEvent(event)
-		case <-eq.timer.C:
-			events, ok := eq.GetEvents()
-			if ok {
-				for _, event := range *events {
-					for _, peer := range eq.subs.GetPeerServers() {
-						eq.publishChannel <- &core.PeerEvent{
-							PeerServer: peer.ID,
-							Event:      *event,
-						}
-					}
+			eq.batch = append(eq.batch, event)
+			if len(eq.batch) >= BatchSize {
+				if armed && !timer.Stop() {
+					<-timer.C
 				}
-				eq.ClearEvents()
+				armed = false
+				eq.flush()
+			} else if !armed {
+				timer.Reset(BatchMaxLatency)
+				armed = true
 			}
+		case <-timer.C:
+			armed = false
+			eq.flush()
 		}
 	}
 
@@ -3,34 +3,133 @@ package services
 import (
 	"context"
 	"errors"
+	"expvar"
 	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
 	"github.com/josh-tracey/eventual-agent/internal/pb"
 	"github.com/josh-tracey/eventual-agent/internal/ports"
-	"github.com/josh-tracey/notary"
 	"github.com/josh-tracey/scribe"
 	"google.golang.org/grpc"
 )
 
-var jwtTokenSecret = os.Getenv("JWT_TOKEN_SECRET")
+var (
+	// Parallel ordered lanes events are published to peer servers on,
+	// PUBLISHER_LANES
+	PublisherLanes = 8
+	// Events each lane queues per priority before dropping more of that
+	// priority, PUBLISHER_QUEUE
+	PublisherQueue = 1024
+	// Publishes tried before a consumer group event is given up on, and
+	// failed group publishes in a row before a peer server is removed,
+	// PEER_RETRY_ATTEMPTS
+	PeerRetryAttempts = 5
+	// Wait before retrying a failed group event, doubled for each further
+	// attempt, PEER_RETRY_BACKOFF
+	PeerRetryBackoff = 100 * time.Millisecond
+)
+
+func init() {
+	if lanes, err := strconv.Atoi(os.Getenv("PUBLISHER_LANES")); err == nil && lanes > 0 {
+		PublisherLanes = lanes
+	}
+	if queue, err := strconv.Atoi(os.Getenv("PUBLISHER_QUEUE")); err == nil && queue > 0 {
+		PublisherQueue = queue
+	}
+	if attempts, err := strconv.Atoi(os.Getenv("PEER_RETRY_ATTEMPTS")); err == nil && attempts > 0 {
+		PeerRetryAttempts = attempts
+	}
+	if backoff, err := time.ParseDuration(os.Getenv("PEER_RETRY_BACKOFF")); err == nil && backoff > 0 {
+		PeerRetryBackoff = backoff
+	}
+}
 
+var (
+	// Events dropped for a full lane queue, keyed by priority, published on
+	// /debug/vars
+	publisherDropped = expvar.NewMap("publisher_dropped")
+
+	priorityNames = [core.PriorityLevels]string{"high", "normal", "low"}
+)
+
+// Publisher - Sends events to peer servers. Events for the same peer server
+// and partition key share a lane and are published one after another, other
+// keys are published in parallel. Each lane queues its events by priority and
+// serves them by priority weight.
 type Publisher struct {
 	logger         *scribe.Logger
 	publishChannel chan *core.PeerEvent
+	lanes          []*publishLane
 	subs           *core.Adapter
+	signer         ports.TokenSigner
+	failures       map[string]int
+	failLock       sync.Mutex
+}
+
+// publishLane - Queues of one lane, one per priority. Queuing never blocks,
+// so a backed up priority cannot hold up events of another.
+type publishLane struct {
+	queues [core.PriorityLevels][]*core.PeerEvent
+	ready  chan struct{}
+	lock   sync.Mutex
+}
+
+func newPublishLane() *publishLane {
+	return &publishLane{ready: make(chan struct{}, 1)}
 }
 
-func NewPublisher(subs ports.SubjectPort, logger *scribe.Logger, publishChannel chan *core.PeerEvent) (*Publisher, error) {
+// push - Queues an event, false when its priority's queue is full
+func (l *publishLane) push(peerEvent *core.PeerEvent, priority core.Priority) bool {
+	l.lock.Lock()
+	if len(l.queues[priority]) >= PublisherQueue {
+		l.lock.Unlock()
+		return false
+	}
+	l.queues[priority] = append(l.queues[priority], peerEvent)
+	l.lock.Unlock()
+
+	select {
+	case l.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// pop - Next event by priority weight, nil when every queue is empty
+func (l *publishLane) pop(weighted *core.WeightedLanes) *core.PeerEvent {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	priority := weighted.Next(func(priority core.Priority) bool {
+		return len(l.queues[priority]) > 0
+	})
+	if priority < 0 {
+		return nil
+	}
+	peerEvent := l.queues[priority][0]
+	l.queues[priority][0] = nil
+	l.queues[priority] = l.queues[priority][1:]
+	return peerEvent
+}
+
+func NewPublisher(subs ports.SubjectPort, logger *scribe.Logger, publishChannel chan *core.PeerEvent, signer ports.TokenSigner) (*Publisher, error) {
 	value, err := subs.(*core.Adapter)
 	if !err {
 		return nil, errors.New("Invalid Subject Port")
 	}
+	lanes := make([]*publishLane, PublisherLanes)
+	for i := range lanes {
+		lanes[i] = newPublishLane()
+	}
 	return &Publisher{
 			subs:           value,
 			logger:         logger,
 			publishChannel: publishChannel,
+			lanes:          lanes,
+			signer:         signer,
+			failures:       make(map[string]int),
 		},
 		nil
 }
@@ -48,7 +147,7 @@ func (p *Publisher) Publish(ctx context.Context, event *core.PeerEvent) error {
 	c, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	token, err := notary.New(jwtTokenSecret).NewSignedToken()
+	token, err := p.signer.Sign()
 	if err != nil {
 		return err
 	}
@@ -56,15 +155,11 @@ func (p *Publisher) Publish(ctx context.Context, event *core.PeerEvent) error {
 	_, err2 := client.Publish(
 		c,
 		&pb.EventPubRequest{
-			Token: token,
-			Data: &pb.CloudEvent{
-				Id:      event.Event.ID,
-				Type:    event.Event.Type,
-				Subject: event.Event.Subject,
-				Time:    event.Event.Time,
-				Source:  event.Event.Source,
-				Data:    &pb.CloudEvent_TextData{TextData: event.Event.Data},
-			},
+			Token:      token,
+			Channel:    direct(event),
+			Data:       pb.FromCore(event.Event),
+			From:       event.From,
+			Connection: event.Connection,
 		}, grpc.FailFast(true))
 
 	if err2 != nil {
@@ -74,22 +169,111 @@ func (p *Publisher) Publish(ctx context.Context, event *core.PeerEvent) error {
 	return nil
 }
 
+// direct - Inbox channel of a direct message, empty for published events
+func direct(event *core.PeerEvent) string {
+	if event.From == "" {
+		return ""
+	}
+	return event.Channel
+}
+
+// redistribute - Retries a consumer group event after a backoff, handing it
+// to the member next in turn. The failed peer server stays in its group until
+// failed removes it, so a transient error never turns it into a receiver of
+// every event.
+func (p *Publisher) redistribute(peerEvent *core.PeerEvent) {
+	if peerEvent.Group == "" {
+		return
+	}
+	retry := *peerEvent
+	retry.Attempts++
+	if retry.Attempts >= PeerRetryAttempts {
+		p.logger.Error("services::Publisher.redistribute => dropping event %s for group %s on %s after %d attempts", retry.Event.ID, retry.Group, retry.Channel, retry.Attempts)
+		return
+	}
+	if next, ok := p.subs.PickPeer(retry.Channel, retry.Group); ok {
+		retry.PeerServer = next
+	}
+	time.AfterFunc(PeerRetryBackoff<<(retry.Attempts-1), func() {
+		p.publishChannel <- &retry
+	})
+}
+
 func (p *Publisher) Run() {
+	for _, lane := range p.lanes {
+		go p.runLane(lane)
+	}
 
+	for peerEvent := range p.publishChannel {
+		key := peerEvent.PeerServer + "\x00" + core.PartitionKey(peerEvent.Event)
+		priority := core.PriorityOf(peerEvent.Event)
+		if !p.lanes[core.Partition(key, len(p.lanes))].push(peerEvent, priority) {
+			publisherDropped.Add(priorityNames[priority], 1)
+			p.logger.Warn("services::Publisher.Run => lane full, dropping event %s for %s", peerEvent.Event.ID, peerEvent.PeerServer)
+		}
+	}
+}
+
+func (p *Publisher) runLane(lane *publishLane) {
+	var weighted core.WeightedLanes
 	for {
-		select {
-		case peerEvent := <-p.publishChannel:
-			ok := p.subs.HasPeerId(peerEvent.PeerServer, false)
-			if ok {
-				start := time.Now()
-				p.logger.Trace("Publishing Event => '%v' to Peer Server %v ", peerEvent.Event, peerEvent.PeerServer)
-				err := p.Publish(context.Background(), peerEvent)
-				if err != nil {
-					p.logger.Error("Error publishing event: %v", err)
-				}
-				p.logger.Duration(start, "Publishing event to peer")
-			}
+		peerEvent := lane.pop(&weighted)
+		if peerEvent == nil {
+			<-lane.ready
+			continue
 		}
+		p.send(peerEvent)
+	}
+}
+
+func (p *Publisher) send(peerEvent *core.PeerEvent) {
+	if core.DiscardExpired(peerEvent.Event, "publisher") {
+		return
 	}
+	ok := p.subs.HasPeerId(peerEvent.PeerServer, false)
+	if !ok {
+		// Removed since the event was queued, its group takes the event over
+		p.redistribute(peerEvent)
+		return
+	}
+
+	start := time.Now()
+	p.logger.Trace("Publishing Event => '%v' to Peer Server %v ", peerEvent.Event, peerEvent.PeerServer)
+	err := p.Publish(context.Background(), peerEvent)
+	if err != nil {
+		p.logger.Error("Error publishing event: %v", err)
+		p.failed(peerEvent)
+		p.redistribute(peerEvent)
+	} else {
+		p.delivered(peerEvent.PeerServer)
+	}
+	p.logger.Duration(start, "Publishing event to peer")
+}
+
+// failed - Counts a failed consumer group publish, removing the peer server,
+// and with it its group memberships, once PeerRetryAttempts in a row failed
+func (p *Publisher) failed(peerEvent *core.PeerEvent) {
+	if peerEvent.Group == "" {
+		return
+	}
+
+	p.failLock.Lock()
+	p.failures[peerEvent.PeerServer]++
+	dead := p.failures[peerEvent.PeerServer] >= PeerRetryAttempts
+	if dead {
+		delete(p.failures, peerEvent.PeerServer)
+	}
+	p.failLock.Unlock()
+
+	if dead {
+		p.logger.Warn("services::Publisher.failed => removing peer server %s after %d failed publishes in a row", peerEvent.PeerServer, PeerRetryAttempts)
+		p.subs.RemovePeer(peerEvent.PeerServer, false)
+	}
+}
 
+// delivered - Resets a peer server's failed publishes
+func (p *Publisher) delivered(peerServer string) {
+	p.failLock.Lock()
+	delete(p.failures, peerServer)
+	p.failLock.Unlock()
 }
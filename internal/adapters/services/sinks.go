@@ -0,0 +1,121 @@
+package services
+
+import (
+	"expvar"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
+	"github.com/josh-tracey/eventual-agent/internal/ports"
+	"github.com/josh-tracey/scribe"
+)
+
+// Copies queued per sink before further copies are dropped, SINK_BUFFER
+var SinkBuffer = 1024
+
+func init() {
+	if size, err := strconv.Atoi(os.Getenv("SINK_BUFFER")); err == nil && size > 0 {
+		SinkBuffer = size
+	}
+}
+
+var (
+	// Copies a sink failed to take, keyed by sink, published on /debug/vars
+	sinkErrors = expvar.NewMap("sink_errors")
+	// Copies dropped for a full queue or an unknown sink, keyed by sink
+	sinkDropped = expvar.NewMap("sink_dropped")
+)
+
+type sinkLane struct {
+	config core.SinkConfig
+	sink   ports.SinkPort
+	queue  chan core.CloudEvent
+}
+
+// Sinks - Delivers the copies routing rules make to their sinks. Each sink
+// drains its own queue so a slow sink never holds up publishing.
+type Sinks struct {
+	logger *scribe.Logger
+	copies chan *core.SinkCopy
+	open   func(config core.SinkConfig) (ports.SinkPort, error)
+	lanes  map[string]*sinkLane
+	lock   sync.RWMutex
+}
+
+func NewSinks(logger *scribe.Logger, copies chan *core.SinkCopy, open func(config core.SinkConfig) (ports.SinkPort, error)) *Sinks {
+	return &Sinks{
+		logger: logger,
+		copies: copies,
+		open:   open,
+		lanes:  make(map[string]*sinkLane),
+	}
+}
+
+// Configure - Opens the sinks that are new or changed and closes the ones no
+// longer declared once their queued copies are sent. A sink that fails to
+// open keeps its previous configuration.
+func (s *Sinks) Configure(configs []core.SinkConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	declared := make(map[string]bool, len(configs))
+	for _, config := range configs {
+		declared[config.Name] = true
+		if lane, ok := s.lanes[config.Name]; ok && lane.config == config {
+			continue
+		}
+
+		sink, err := s.open(config)
+		if err != nil {
+			s.logger.Error("services::Sinks.Configure => %s", err)
+			continue
+		}
+		if old, ok := s.lanes[config.Name]; ok {
+			close(old.queue)
+		}
+		lane := &sinkLane{config: config, sink: sink, queue: make(chan core.CloudEvent, SinkBuffer)}
+		s.lanes[config.Name] = lane
+		go s.drain(lane)
+	}
+
+	for name, lane := range s.lanes {
+		if !declared[name] {
+			close(lane.queue)
+			delete(s.lanes, name)
+		}
+	}
+}
+
+func (s *Sinks) drain(lane *sinkLane) {
+	for event := range lane.queue {
+		if err := lane.sink.Send(event); err != nil {
+			sinkErrors.Add(lane.config.Name, 1)
+			s.logger.Error("services::Sinks.drain => %s: %s", lane.config.Name, err)
+		}
+	}
+	if err := lane.sink.Close(); err != nil {
+		s.logger.Error("services::Sinks.drain => closing %s: %s", lane.config.Name, err)
+	}
+}
+
+// Run - Hands each copy to its sink's queue, dropping it when the queue is
+// full
+func (s *Sinks) Run() {
+	for copy := range s.copies {
+		s.lock.RLock()
+		lane, ok := s.lanes[copy.Sink]
+		if ok {
+			select {
+			case lane.queue <- copy.Event:
+			default:
+				ok = false
+			}
+		}
+		s.lock.RUnlock()
+
+		if !ok {
+			sinkDropped.Add(copy.Sink, 1)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package pb
+
+import "github.com/josh-tracey/eventual-agent/internal/adapters/core"
+
+// Optional context attribute carried in the attributes map alongside
+// extensions
+const attrDataContentType = "datacontenttype"
+
+// FromCore - Protobuf form of a core CloudEvent, datacontenttype and
+// extensions are carried as string attributes
+func FromCore(event core.CloudEvent) *CloudEvent {
+	pbEvent := &CloudEvent{
+		Id:          event.ID,
+		Type:        event.Type,
+		Subject:     event.Subject,
+		Time:        event.Time,
+		Source:      event.Source,
+		SpecVersion: event.SpecVersion,
+		SchemaUrl:   event.DataSchema,
+		Data:        &CloudEvent_TextData{TextData: event.Data},
+	}
+	if len(event.Extensions) > 0 || event.DataContentType != "" {
+		pbEvent.Attributes = make(map[string]*CloudEvent_CloudEventAttributeValue, len(event.Extensions)+1)
+		for name, value := range event.Extensions {
+			pbEvent.Attributes[name] = &CloudEvent_CloudEventAttributeValue{
+				Attr: &CloudEvent_CloudEventAttributeValue_CeString{CeString: value},
+			}
+		}
+		if event.DataContentType != "" {
+			pbEvent.Attributes[attrDataContentType] = &CloudEvent_CloudEventAttributeValue{
+				Attr: &CloudEvent_CloudEventAttributeValue_CeString{CeString: event.DataContentType},
+			}
+		}
+	}
+	return pbEvent
+}
+
+// ToCore - Core form of a protobuf CloudEvent, keeping its string attributes
+// other than datacontenttype as extensions
+func ToCore(event *CloudEvent) core.CloudEvent {
+	coreEvent := core.CloudEvent{
+		ID:          event.GetId(),
+		Source:      event.GetSource(),
+		Type:        event.GetType(),
+		Subject:     event.GetSubject(),
+		Data:        event.GetTextData(),
+		Time:        event.GetTime(),
+		SpecVersion: event.GetSpecVersion(),
+		DataSchema:  event.GetSchemaUrl(),
+	}
+	for name, value := range event.GetAttributes() {
+		if s, ok := value.GetAttr().(*CloudEvent_CloudEventAttributeValue_CeString); ok {
+			if name == attrDataContentType {
+				coreEvent.DataContentType = s.CeString
+				continue
+			}
+			if coreEvent.Extensions == nil {
+				coreEvent.Extensions = make(map[string]string)
+			}
+			coreEvent.Extensions[name] = s.CeString
+		}
+	}
+	return coreEvent
+}
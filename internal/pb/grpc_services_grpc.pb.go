@@ -24,6 +24,10 @@ const _ = grpc.SupportPackageIsVersion7
 type ClientServiceClient interface {
 	Subscribe(ctx context.Context, in *EventSubRequest, opts ...grpc.CallOption) (*EventSubResponse, error)
 	Publish(ctx context.Context, in *EventPubRequest, opts ...grpc.CallOption) (*EventPubResponse, error)
+	History(ctx context.Context, in *EventHistoryRequest, opts ...grpc.CallOption) (*EventHistoryResponse, error)
+	Request(ctx context.Context, in *EventRequestRequest, opts ...grpc.CallOption) (*EventRequestResponse, error)
+	Reply(ctx context.Context, in *EventReplyRequest, opts ...grpc.CallOption) (*EventReplyResponse, error)
+	CancelScheduled(ctx context.Context, in *EventCancelRequest, opts ...grpc.CallOption) (*EventCancelResponse, error)
 }
 
 type clientServiceClient struct {
@@ -52,12 +56,52 @@ func (c *clientServiceClient) Publish(ctx context.Context, in *EventPubRequest,
 	return out, nil
 }
 
+func (c *clientServiceClient) History(ctx context.Context, in *EventHistoryRequest, opts ...grpc.CallOption) (*EventHistoryResponse, error) {
+	out := new(EventHistoryResponse)
+	err := c.cc.Invoke(ctx, "/ClientService/History", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clientServiceClient) Request(ctx context.Context, in *EventRequestRequest, opts ...grpc.CallOption) (*EventRequestResponse, error) {
+	out := new(EventRequestResponse)
+	err := c.cc.Invoke(ctx, "/ClientService/Request", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clientServiceClient) Reply(ctx context.Context, in *EventReplyRequest, opts ...grpc.CallOption) (*EventReplyResponse, error) {
+	out := new(EventReplyResponse)
+	err := c.cc.Invoke(ctx, "/ClientService/Reply", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clientServiceClient) CancelScheduled(ctx context.Context, in *EventCancelRequest, opts ...grpc.CallOption) (*EventCancelResponse, error) {
+	out := new(EventCancelResponse)
+	err := c.cc.Invoke(ctx, "/ClientService/CancelScheduled", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ClientServiceServer is the server API for ClientService service.
 // All implementations must embed UnimplementedClientServiceServer
 // for forward compatibility
 type ClientServiceServer interface {
 	Subscribe(context.Context, *EventSubRequest) (*EventSubResponse, error)
 	Publish(context.Context, *EventPubRequest) (*EventPubResponse, error)
+	History(context.Context, *EventHistoryRequest) (*EventHistoryResponse, error)
+	Request(context.Context, *EventRequestRequest) (*EventRequestResponse, error)
+	Reply(context.Context, *EventReplyRequest) (*EventReplyResponse, error)
+	CancelScheduled(context.Context, *EventCancelRequest) (*EventCancelResponse, error)
 	mustEmbedUnimplementedClientServiceServer()
 }
 
@@ -71,6 +115,18 @@ func (UnimplementedClientServiceServer) Subscribe(context.Context, *EventSubRequ
 func (UnimplementedClientServiceServer) Publish(context.Context, *EventPubRequest) (*EventPubResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Publish not implemented")
 }
+func (UnimplementedClientServiceServer) History(context.Context, *EventHistoryRequest) (*EventHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method History not implemented")
+}
+func (UnimplementedClientServiceServer) Request(context.Context, *EventRequestRequest) (*EventRequestResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Request not implemented")
+}
+func (UnimplementedClientServiceServer) Reply(context.Context, *EventReplyRequest) (*EventReplyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reply not implemented")
+}
+func (UnimplementedClientServiceServer) CancelScheduled(context.Context, *EventCancelRequest) (*EventCancelResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelScheduled not implemented")
+}
 func (UnimplementedClientServiceServer) mustEmbedUnimplementedClientServiceServer() {}
 
 // UnsafeClientServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -120,6 +176,78 @@ func _ClientService_Publish_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ClientService_History_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EventHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientServiceServer).History(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ClientService/History",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientServiceServer).History(ctx, req.(*EventHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClientService_Request_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EventRequestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientServiceServer).Request(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ClientService/Request",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientServiceServer).Request(ctx, req.(*EventRequestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClientService_Reply_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EventReplyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientServiceServer).Reply(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ClientService/Reply",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientServiceServer).Reply(ctx, req.(*EventReplyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClientService_CancelScheduled_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EventCancelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientServiceServer).CancelScheduled(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ClientService/CancelScheduled",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientServiceServer).CancelScheduled(ctx, req.(*EventCancelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ClientService_ServiceDesc is the grpc.ServiceDesc for ClientService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -135,6 +263,22 @@ var ClientService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Publish",
 			Handler:    _ClientService_Publish_Handler,
 		},
+		{
+			MethodName: "History",
+			Handler:    _ClientService_History_Handler,
+		},
+		{
+			MethodName: "Request",
+			Handler:    _ClientService_Request_Handler,
+		},
+		{
+			MethodName: "Reply",
+			Handler:    _ClientService_Reply_Handler,
+		},
+		{
+			MethodName: "CancelScheduled",
+			Handler:    _ClientService_CancelScheduled_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "grpc_services.proto",
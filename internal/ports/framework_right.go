@@ -1,6 +1,8 @@
 package ports
 
 import (
+	"time"
+
 	"github.com/josh-tracey/eventual-agent/internal/adapters/core"
 )
 
@@ -8,4 +10,44 @@ type MessageQueuePort interface {
 	Enqueue(channel string, message core.CloudEvent)
 	Dequeue(channel string, consume bool) (core.CloudEvent, error)
 	Iter(channel string, consume bool) (chan core.CloudEvent, error)
+	History(channel string, query core.HistoryQuery) (core.HistoryPage, error)
+}
+
+// CursorStorePort - Persists durable subscriptions and their cursors
+type CursorStorePort interface {
+	Load() ([]core.Durable, error)
+	Save(durables []core.Durable) error
+}
+
+// ScheduleStorePort - Persists events waiting for their delivery time
+type ScheduleStorePort interface {
+	Load() ([]core.ScheduledEvent, error)
+	Save(events []core.ScheduledEvent) error
+}
+
+// SchemaStorePort - Supplies the JSON Schemas event data is validated against
+type SchemaStorePort interface {
+	Load() ([]core.SchemaDocument, error)
+}
+
+// RulesStorePort - Supplies the routing table, reloaded when it is modified
+type RulesStorePort interface {
+	Load() (core.RoutingTable, error)
+	Modified() (time.Time, error)
+}
+
+// SinkPort - Destination routing rules copy events to
+type SinkPort interface {
+	Send(event core.CloudEvent) error
+	Close() error
+}
+
+// TokenVerifier - Verifies bearer tokens presented by clients and peers
+type TokenVerifier interface {
+	Verify(token string) (*core.Claims, error)
+}
+
+// TokenSigner - Issues tokens for outgoing calls to peer servers
+type TokenSigner interface {
+	Sign() (string, error)
 }